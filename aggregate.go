@@ -0,0 +1,161 @@
+package borm
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// AggregateFunc is a aggregation function used by TSEngine.Aggregate
+type AggregateFunc int
+
+const (
+	AggCount AggregateFunc = iota
+	AggSum
+	AggMin
+	AggMax
+	AggAvg
+)
+
+func (fn AggregateFunc) String() string {
+	switch fn {
+	case AggCount:
+		return "count"
+	case AggSum:
+		return "sum"
+	case AggMin:
+		return "min"
+	case AggMax:
+		return "max"
+	case AggAvg:
+		return "avg"
+	default:
+		return "unknown"
+	}
+}
+
+// Extractor read a value from the current record of the iterator
+type Extractor func(it *Iterator) (float64, error)
+
+// AggregateWindow is the aggregated result of a time window,
+// Values is in the same order as the AggregateFuncs passed to Aggregate.
+type AggregateWindow struct {
+	Start  time.Time
+	End    time.Time
+	Count  int
+	Values []float64
+}
+
+type aggregator struct {
+	window AggregateWindow
+	sum    float64
+	min    float64
+	max    float64
+}
+
+func (agg *aggregator) add(value float64) {
+	if agg.window.Count == 0 {
+		agg.min = value
+		agg.max = value
+	} else {
+		agg.min = math.Min(agg.min, value)
+		agg.max = math.Max(agg.max, value)
+	}
+	agg.sum += value
+	agg.window.Count++
+}
+
+func (agg *aggregator) result(fns []AggregateFunc) AggregateWindow {
+	w := agg.window
+	w.Values = make([]float64, len(fns))
+	for idx, fn := range fns {
+		switch fn {
+		case AggCount:
+			w.Values[idx] = float64(w.Count)
+		case AggSum:
+			w.Values[idx] = agg.sum
+		case AggMin:
+			w.Values[idx] = agg.min
+		case AggMax:
+			w.Values[idx] = agg.max
+		case AggAvg:
+			w.Values[idx] = agg.sum / float64(w.Count)
+		}
+	}
+	return w
+}
+
+// Aggregate groups the records between start and end into windows by the time of their id,
+// and applies fns to the values read by extractor. The windows are aligned to the
+// clock of the location of the engine like the shards. Records are streamed across
+// shards, only one accumulator per window is kept in memory. Windows without any
+// record are omitted from the result.
+func (db *TSEngine) Aggregate(start, end time.Time, window time.Duration,
+	extractor Extractor, fns ...AggregateFunc) ([]AggregateWindow, error) {
+	if window <= 0 {
+		return nil, errors.New("window is invalid")
+	}
+	if len(fns) == 0 {
+		fns = []AggregateFunc{AggCount}
+	}
+	for _, fn := range fns {
+		if fn < AggCount || fn > AggAvg {
+			return nil, errors.New("aggregate function is invalid")
+		}
+	}
+
+	aggregators := map[int64]*aggregator{}
+	err := db.Query(start, end, func(it *Iterator) error {
+		for it.Next() {
+			t := TimeFromID(string(it.Key()))
+			if t.IsZero() {
+				continue
+			}
+
+			value, err := extractor(it)
+			if err != nil {
+				return err
+			}
+
+			windowStart, windowEnd := alignWindow(t, window, db.loc)
+			agg := aggregators[windowStart.Unix()]
+			if agg == nil {
+				agg = &aggregator{window: AggregateWindow{
+					Start: windowStart,
+					End:   windowEnd,
+				}}
+				aggregators[windowStart.Unix()] = agg
+			}
+			agg.add(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]AggregateWindow, 0, len(aggregators))
+	for _, agg := range aggregators {
+		results = append(results, agg.result(fns))
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Start.Before(results[j].Start)
+	})
+	return results, nil
+}
+
+// alignWindow returns the window of t, the windows are aligned to the wall clock
+// of loc as the shards are split by its days, so that a window which divides a
+// day doesn't straddle two shards.
+func alignWindow(t time.Time, window time.Duration, loc *time.Location) (time.Time, time.Time) {
+	wall := inLocation(t.In(loc), time.UTC)
+	start := wall.Truncate(window)
+	end := start.Add(window)
+	return inLocation(start, loc), inLocation(end, loc)
+}
+
+// inLocation returns the time of loc with the wall clock of t.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
@@ -0,0 +1,125 @@
+package borm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+type sample struct {
+	Value float64
+}
+
+func TestAggregate(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 11, 23, 58, 0, 0, time.UTC)
+		for i := 0; i < 6; i++ {
+			at := start.Add(time.Duration(i) * 30 * time.Second)
+			err := db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, uint32(i)), &sample{Value: float64(i)})
+			})
+			if err != nil {
+				t.Fatalf("Error writing sample %d: %s", i, err)
+			}
+		}
+
+		results, err := db.Aggregate(start, start.Add(10*time.Minute), time.Minute,
+			func(it *borm.Iterator) (float64, error) {
+				var s sample
+				err := it.Read(&s)
+				return s.Value, err
+			}, borm.AggCount, borm.AggSum, borm.AggMin, borm.AggMax, borm.AggAvg)
+		if err != nil {
+			t.Fatalf("Error aggregating: %s", err)
+		}
+
+		if len(results) != 3 {
+			t.Fatalf("Got %d windows wanted 3", len(results))
+		}
+		for idx, w := range results {
+			if !w.Start.Equal(start.Add(time.Duration(idx) * time.Minute)) {
+				t.Fatalf("window %d: got start %v", idx, w.Start)
+			}
+			first := float64(2 * idx)
+			expected := []float64{2, 2*first + 1, first, first + 1, first + 0.5}
+			for i, v := range expected {
+				if w.Values[i] != v {
+					t.Fatalf("window %d: got %v wanted %v", idx, w.Values, expected)
+				}
+			}
+		}
+	})
+}
+
+func TestAggregateLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	db, err := borm.OpenTSIn(dir, loc)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	extractor := func(it *borm.Iterator) (string, float64, error) {
+		var s seriesSample
+		err := it.Read(&s)
+		return s.Series, s.Value, err
+	}
+	if err := db.AddRollup(borm.Rollup{Name: "1d", Interval: 24 * time.Hour, Extractor: extractor}); err != nil {
+		t.Fatalf("Error adding rollup: %s", err)
+	}
+
+	// two records of each day of the engine, the days of UTC split them.
+	start := time.Date(2017, 10, 11, 1, 0, 0, 0, loc)
+	for i := 0; i < 4; i++ {
+		at := start.Add(time.Duration(i) * 12 * time.Hour)
+		err := db.Write(at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(at, uint32(i)), &seriesSample{Series: "cpu", Value: float64(i)})
+		})
+		if err != nil {
+			t.Fatalf("Error writing sample %d: %s", i, err)
+		}
+	}
+
+	results, err := db.Aggregate(start, start.AddDate(0, 0, 2), 24*time.Hour,
+		func(it *borm.Iterator) (float64, error) {
+			var s seriesSample
+			err := it.Read(&s)
+			return s.Value, err
+		})
+	if err != nil {
+		t.Fatalf("Error aggregating: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Got %d windows wanted 2", len(results))
+	}
+	for idx, w := range results {
+		day := time.Date(2017, 10, 11+idx, 0, 0, 0, 0, loc)
+		if !w.Start.Equal(day) || !w.End.Equal(day.AddDate(0, 0, 1)) || w.Count != 2 {
+			t.Fatalf("window %d: got %v - %v with %d records", idx, w.Start, w.End, w.Count)
+		}
+	}
+
+	if err := db.ComputeRollups(start, start.AddDate(0, 0, 2)); err != nil {
+		t.Fatalf("Error computing rollups: %s", err)
+	}
+	var days []borm.RollupPoint
+	err = db.QueryRollup("cpu", start, start.AddDate(0, 0, 2), 24*time.Hour, func(r *borm.Rollup, p *borm.RollupPoint) error {
+		days = append(days, *p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying rollup: %s", err)
+	}
+	if len(days) != 2 || !days[0].Start.Equal(time.Date(2017, 10, 11, 0, 0, 0, 0, loc)) || days[0].Count != 2 || days[1].Count != 2 {
+		t.Fatalf("Got %#v", days)
+	}
+}
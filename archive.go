@@ -0,0 +1,254 @@
+package borm
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const archiveExt = ".gz"
+
+func (db *TSEngine) archiveDir() string {
+	return filepath.Join(db.basePath, "archive")
+}
+
+func (db *TSEngine) archiveCacheDir() string {
+	return filepath.Join(db.archiveDir(), ".cache")
+}
+
+// ArchiveBefore compacts and compresses the shards whose time range ends before t
+// into the archive directory, the shards open for writing are never archived. Archived
+// shards are still readable by Read, Query and Get, a write to an archived shard
+// restores it from the archive.
+func (db *TSEngine) ArchiveBefore(t time.Time) (Shards, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushBuffer(); err != nil {
+		return nil, err
+	}
+
+	var archived Shards
+	for _, shard := range db.shards() {
+		if shard.endTime.After(t) || db.writers[shard.path] != nil {
+			continue
+		}
+		if err := db.archiveShard(shard); err != nil {
+			return archived, err
+		}
+		archived = append(archived, shard)
+	}
+	return archived, nil
+}
+
+func (db *TSEngine) archiveShard(shard *Shard) error {
+	// the shard may be removed or opened for writing while it is read.
+	if !db.waitIdle(shard.path) || db.writers[shard.path] != nil {
+		return nil
+	}
+	if err := os.MkdirAll(db.archiveDir(), 0755); err != nil {
+		return err
+	}
+
+	compacted := filepath.Join(db.archiveDir(), "."+filepath.Base(shard.path)+".compact")
+	defer os.Remove(compacted)
+	if err := compactFile(compacted, shard.path); err != nil {
+		return err
+	}
+	var stats ShardStats
+	if err := readFileStats(compacted, &stats); err != nil {
+		return err
+	}
+
+	// a shard is restored from the archive before it is written again, so an
+	// existing archive has records which aren't in the shard.
+	target := filepath.Join(db.archiveDir(), filepath.Base(shard.path)+archiveExt)
+	if _, err := os.Stat(target); err == nil {
+		return errors.New("archive of shard '" + filepath.Base(shard.path) + "' already exists")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := gzipFile(target, compacted); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(db.archiveCacheDir(), filepath.Base(shard.path)))
+	if err := os.Remove(shard.path); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	return db.manifest.archive(shard.path, fi.Size(), &stats)
+}
+
+// restoreShard decompresses an archived shard back into the base path before it
+// is opened for writing, the archive is removed after so that the shard is
+// archived again with all records.
+func (db *TSEngine) restoreShard(fileName string) error {
+	if !db.manifest.archived(fileName) {
+		return nil
+	}
+	// the shard is already restored if a crash happened before the manifest
+	// is saved.
+	if _, err := os.Stat(fileName); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	source := filepath.Join(db.archiveDir(), filepath.Base(fileName)+archiveExt)
+	if err := gunzipFile(fileName, source); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(db.archiveCacheDir(), filepath.Base(fileName)))
+	return os.Remove(source)
+}
+
+// archivedFile returns the decompressed copy of an archived shard, ok is false
+// if the shard isn't archived.
+func (db *TSEngine) archivedFile(fileName string) (string, bool, error) {
+	source := filepath.Join(db.archiveDir(), filepath.Base(fileName)+archiveExt)
+	sfi, err := os.Stat(source)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	cached := filepath.Join(db.archiveCacheDir(), filepath.Base(fileName))
+	if cfi, err := os.Stat(cached); err == nil && !cfi.ModTime().Before(sfi.ModTime()) {
+		return cached, true, nil
+	}
+
+	if err := os.MkdirAll(db.archiveCacheDir(), 0755); err != nil {
+		return "", false, err
+	}
+	if err := gunzipFile(cached, source); err != nil {
+		return "", false, err
+	}
+	return cached, true, nil
+}
+
+// ClearArchiveCache removes the decompressed copies of archived shards.
+func (db *TSEngine) ClearArchiveCache() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return os.RemoveAll(db.archiveCacheDir())
+}
+
+// compactFile copies all buckets of src into a new bolt file dst, keys are
+// inserted in order with a full fill percent so that dst has no free pages.
+func compactFile(dst, src string) error {
+	srcDB, err := bolt.Open(src, 0444, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	os.Remove(dst)
+	dstDB, err := bolt.Open(dst, 0666, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return err
+	}
+
+	err = srcDB.View(func(srcTx *bolt.Tx) error {
+		return dstDB.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				bkt, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(bkt, b)
+			})
+		})
+	})
+	if err != nil {
+		dstDB.Close()
+		return err
+	}
+	return dstDB.Close()
+}
+
+func copyBucket(dst, src *bolt.Bucket) error {
+	dst.FillPercent = 1.0
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		child, err := dst.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(child, src.Bucket(k))
+	})
+}
+
+// gzipFile compresses src into dst, dst is written to a temp file and renamed.
+func gzipFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if _, err := io.Copy(zw, in); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// gunzipFile decompresses src into dst, dst is written to a temp file and renamed.
+func gunzipFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, zr)
+		return err
+	})
+}
+
+func writeFileAtomic(dst string, write func(w io.Writer) error) error {
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if err := write(out); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,115 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestArchiveBefore(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+		writeDays(t, db, start, 3)
+
+		archived, err := db.ArchiveBefore(start.AddDate(0, 0, 2))
+		if err != nil {
+			t.Fatalf("Error archiving: %s", err)
+		}
+		if len(archived) != 2 {
+			t.Fatalf("Got %d archived wanted 2", len(archived))
+		}
+		for _, shard := range archived {
+			if _, err := os.Stat(shard.Path()); !os.IsNotExist(err) {
+				t.Fatalf("%s isn't removed", shard.Path())
+			}
+		}
+
+		var count int
+		err = db.Query(start, start.AddDate(0, 0, 3), func(it *borm.Iterator) error {
+			for it.Next() {
+				var s sample
+				if err := it.Read(&s); err != nil {
+					return err
+				}
+				if s.Value != float64(count) {
+					t.Fatalf("Got %v wanted %v", s.Value, count)
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 3 {
+			t.Fatalf("Got %d records wanted 3", count)
+		}
+
+		var s sample
+		if err := db.Get(borm.CreateID(start, 0), &s); err != nil {
+			t.Fatalf("Error getting archived record: %s", err)
+		}
+
+		if err := db.ClearArchiveCache(); err != nil {
+			t.Fatalf("Error clearing archive cache: %s", err)
+		}
+	})
+}
+
+func TestArchiveLateWrite(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+		writeDays(t, db, start, 3)
+		at := start.Add(time.Hour)
+		if err := db.Write(at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(at, 0), &sample{Value: 10})
+		}); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+
+		count := func() int {
+			var count int
+			err := db.Query(start.Add(-time.Hour), start.Add(2*time.Hour), func(it *borm.Iterator) error {
+				for it.Next() {
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error querying: %s", err)
+			}
+			return count
+		}
+
+		if _, err := db.ArchiveBefore(start.AddDate(0, 0, 1)); err != nil {
+			t.Fatalf("Error archiving: %s", err)
+		}
+
+		// the late write restores the archived shard.
+		late := start.Add(-time.Hour)
+		if err := db.Write(late, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(late, 0), &sample{Value: 20})
+		}); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+		if n := count(); n != 3 {
+			t.Fatalf("Got %d records wanted 3", n)
+		}
+
+		archived, err := db.ArchiveBefore(start.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatalf("Error archiving: %s", err)
+		}
+		if len(archived) != 1 {
+			t.Fatalf("Got %d archived wanted 1", len(archived))
+		}
+		if err := db.ClearArchiveCache(); err != nil {
+			t.Fatalf("Error clearing archive cache: %s", err)
+		}
+		if n := count(); n != 3 {
+			t.Fatalf("Got %d records wanted 3", n)
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package borm
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrBatchClosed is returned by the writes to a closed BatchWriter
+var ErrBatchClosed = errors.New("The batch writer is closed")
+
+// Pair is a key and a value of the batch writes
+type Pair struct {
+	Key   string
+	Value interface{}
+}
+
+// InsertBatch inserts pairs in one transaction, no pair is inserted if a key
+// already exists or a value can't be encoded.
+func (b *Bucket) InsertBatch(pairs []Pair) error {
+	return b.Write(func(u Updater) error {
+		for _, pair := range pairs {
+			if err := u.Insert(pair.Key, pair.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpsertBatch inserts or updates pairs in one transaction.
+func (b *Bucket) UpsertBatch(pairs []Pair) error {
+	return b.Write(func(u Updater) error {
+		for _, pair := range pairs {
+			if err := u.Upsert(pair.Key, pair.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BatchOptions are the flush limits of a BatchWriter
+type BatchOptions struct {
+	// MaxSize flushes the pending writes when there are MaxSize writes, defaults to 1000.
+	MaxSize int
+	// Interval flushes the pending writes after the first one waits Interval, defaults to 10ms.
+	Interval time.Duration
+}
+
+// Future is the result of a write of a BatchWriter
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(err error) *Future {
+	f.err = err
+	close(f.done)
+	return f
+}
+
+// Done is closed when the write is committed or failed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the write and returns its error.
+func (f *Future) Wait() error {
+	<-f.done
+	return f.err
+}
+
+type batchItem struct {
+	key    []byte
+	value  []byte
+	insert bool
+	flush  bool
+	future *Future
+}
+
+// BatchWriter coalesces the writes of many goroutines into one transaction, so
+// the writes share one fsync. A insert of a existing key or a write which bolt
+// rejects, such as a empty key, fails alone, the other writes of the transaction
+// are committed.
+type BatchWriter struct {
+	b    *Bucket
+	opts BatchOptions
+
+	mu     sync.Mutex
+	closed bool
+	items  chan *batchItem
+	done   chan struct{}
+}
+
+// NewBatchWriter starts a BatchWriter of the bucket, it must be closed by Close.
+func (b *Bucket) NewBatchWriter(opts BatchOptions) *BatchWriter {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 1000
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Millisecond
+	}
+
+	w := &BatchWriter{
+		b:     b,
+		opts:  opts,
+		items: make(chan *batchItem, opts.MaxSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Insert inserts value in the next transaction, the future fails with
+// ErrKeyExists if the key already exists.
+func (w *BatchWriter) Insert(key string, value interface{}) *Future {
+	return w.write(key, value, true)
+}
+
+// Upsert inserts or updates value in the next transaction.
+func (w *BatchWriter) Upsert(key string, value interface{}) *Future {
+	return w.write(key, value, false)
+}
+
+func (w *BatchWriter) write(key string, value interface{}, insert bool) *Future {
+	// values are encoded by the callers, so they can be changed after returned.
+	bs, err := w.b.encode(value)
+	if err != nil {
+		return newFuture().resolve(err)
+	}
+	return w.send(&batchItem{key: []byte(key), value: bs, insert: insert, future: newFuture()})
+}
+
+func (w *BatchWriter) send(item *batchItem) *Future {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return item.future.resolve(ErrBatchClosed)
+	}
+	w.items <- item
+	return item.future
+}
+
+// Flush commits the pending writes and waits for them.
+func (w *BatchWriter) Flush() error {
+	return w.send(&batchItem{flush: true, future: newFuture()}).Wait()
+}
+
+// Close commits the pending writes and stops the writer.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrBatchClosed
+	}
+	w.closed = true
+	close(w.items)
+	w.mu.Unlock()
+
+	<-w.done
+	return nil
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.done)
+
+	var pending []*batchItem
+	timer := time.NewTimer(w.opts.Interval)
+	timer.Stop()
+
+	for {
+		select {
+		case item, ok := <-w.items:
+			if !ok {
+				w.commit(pending)
+				return
+			}
+			if item.flush {
+				w.commit(pending)
+				pending = pending[:0]
+				item.future.resolve(nil)
+				continue
+			}
+			if len(pending) == 0 {
+				timer.Reset(w.opts.Interval)
+			}
+			pending = append(pending, item)
+			if len(pending) >= w.opts.MaxSize {
+				w.commit(pending)
+				pending = pending[:0]
+			}
+		case <-timer.C:
+			w.commit(pending)
+			pending = pending[:0]
+		}
+	}
+}
+
+func (w *BatchWriter) commit(items []*batchItem) {
+	if len(items) == 0 {
+		return
+	}
+
+	errs := make([]error, len(items))
+	err := w.b.store.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(w.b.name)
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+
+		for idx, item := range items {
+			if errs[idx] = checkBatchItem(bkt, item); errs[idx] != nil {
+				continue
+			}
+			if err := bkt.Put(item.key, item.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	for idx, item := range items {
+		if err != nil {
+			item.future.resolve(err)
+		} else {
+			item.future.resolve(errs[idx])
+		}
+	}
+}
+
+// checkBatchItem returns the error of a write which bolt would reject, so that
+// the write fails alone instead of the whole transaction.
+func checkBatchItem(bkt *bolt.Bucket, item *batchItem) error {
+	switch {
+	case len(item.key) == 0:
+		return bolt.ErrKeyRequired
+	case len(item.key) > bolt.MaxKeySize:
+		return bolt.ErrKeyTooLarge
+	case int64(len(item.value)) > bolt.MaxValueSize:
+		return bolt.ErrValueTooLarge
+	case bkt.Bucket(item.key) != nil:
+		return bolt.ErrIncompatibleValue
+	case item.insert && bkt.Get(item.key) != nil:
+		return ErrKeyExists
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package borm_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+)
+
+func TestInsertBatch(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+
+		var pairs []borm.Pair
+		for i := 0; i < 100; i++ {
+			pairs = append(pairs, borm.Pair{Key: strconv.Itoa(i), Value: &ItemTest{Key: i}})
+		}
+		if err := bkt.InsertBatch(pairs); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+
+		// the batch is rolled back by the existing key.
+		err = bkt.InsertBatch([]borm.Pair{{Key: "new", Value: &ItemTest{}}, {Key: "1", Value: &ItemTest{}}})
+		if err != borm.ErrKeyExists {
+			t.Fatalf("Got %v wanted ErrKeyExists", err)
+		}
+		if err := bkt.Get("new", &ItemTest{}); err != borm.ErrNotFound {
+			t.Fatalf("Got %v wanted ErrNotFound", err)
+		}
+
+		if err := bkt.UpsertBatch([]borm.Pair{{Key: "new", Value: &ItemTest{Key: -1}}, {Key: "1", Value: &ItemTest{Key: -1}}}); err != nil {
+			t.Fatalf("Error upserting: %s", err)
+		}
+		for _, key := range []string{"new", "1"} {
+			var item ItemTest
+			if err := bkt.Get(key, &item); err != nil || item.Key != -1 {
+				t.Fatalf("Got %v, %v wanted -1", item, err)
+			}
+		}
+	})
+}
+
+func TestBatchWriter(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		if err := bkt.Insert("exists", &ItemTest{}); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+
+		w := bkt.NewBatchWriter(borm.BatchOptions{MaxSize: 64, Interval: time.Millisecond})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 1000)
+		for g := 0; g < 10; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				var futures []*borm.Future
+				for i := 0; i < 100; i++ {
+					futures = append(futures, w.Insert(strconv.Itoa(g*100+i), &ItemTest{Key: g*100 + i}))
+				}
+				for _, f := range futures {
+					if err := f.Wait(); err != nil {
+						errs <- err
+					}
+				}
+			}(g)
+		}
+
+		dup := w.Insert("exists", &ItemTest{})
+		upsert := w.Upsert("exists", &ItemTest{Key: 7})
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("Error writing: %s", err)
+		}
+		if err := dup.Wait(); err != borm.ErrKeyExists {
+			t.Fatalf("Got %v wanted ErrKeyExists", err)
+		}
+		if err := upsert.Wait(); err != nil {
+			t.Fatalf("Error upserting: %s", err)
+		}
+
+		pending := w.Upsert("last", &ItemTest{Key: 1000})
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Error flushing: %s", err)
+		}
+		select {
+		case <-pending.Done():
+		default:
+			t.Fatal("write isn't done after flushed")
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("Error closing: %s", err)
+		}
+		if err := w.Insert("closed", &ItemTest{}).Wait(); err != borm.ErrBatchClosed {
+			t.Fatalf("Got %v wanted ErrBatchClosed", err)
+		}
+
+		var count int
+		bkt.ForEach(func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if count != 1002 {
+			t.Fatalf("Got %d records wanted 1002", count)
+		}
+		var item ItemTest
+		if err := bkt.Get("exists", &item); err != nil || item.Key != 7 {
+			t.Fatalf("Got %v, %v wanted 7", item, err)
+		}
+	})
+}
+
+func TestBatchWriterInvalidKeys(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		err = store.Bolt().Update(func(tx *bolt.Tx) error {
+			_, err := tx.Bucket([]byte("bucktest")).CreateBucket([]byte("child"))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := bkt.NewBatchWriter(borm.BatchOptions{MaxSize: 64, Interval: time.Hour})
+		defer w.Close()
+
+		a := w.Insert("a", &ItemTest{Key: 1})
+		empty := w.Insert("", &ItemTest{})
+		large := w.Upsert(strings.Repeat("k", bolt.MaxKeySize+1), &ItemTest{})
+		child := w.Upsert("child", &ItemTest{})
+		b := w.Upsert("b", &ItemTest{Key: 2})
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Error flushing: %s", err)
+		}
+
+		for _, test := range []struct {
+			future *borm.Future
+			err    error
+		}{
+			{a, nil},
+			{empty, bolt.ErrKeyRequired},
+			{large, bolt.ErrKeyTooLarge},
+			{child, bolt.ErrIncompatibleValue},
+			{b, nil},
+		} {
+			if err := test.future.Wait(); err != test.err {
+				t.Fatalf("Got %v wanted %v", err, test.err)
+			}
+		}
+		for idx, key := range []string{"a", "b"} {
+			var item ItemTest
+			if err := bkt.Get(key, &item); err != nil || item.Key != idx+1 {
+				t.Fatalf("Got %v, %v wanted %d", item, err, idx+1)
+			}
+		}
+	})
+}
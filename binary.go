@@ -0,0 +1,653 @@
+package borm
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// binary.go is the reflection part of the MessagePack and CBOR codecs, the
+// formats implement binaryWriter and binaryReader.
+
+type binaryWriter interface {
+	writeNil()
+	writeBool(b bool)
+	writeInt(i int64)
+	writeUint(u uint64)
+	writeFloat32(f float32)
+	writeFloat64(f float64)
+	writeString(s string)
+	writeBytes(bs []byte)
+	writeArrayHeader(n int)
+	writeMapHeader(n int)
+	writeTime(t time.Time)
+}
+
+type tokenKind int
+
+const (
+	tokenNil tokenKind = iota
+	tokenBool
+	tokenInt
+	tokenUint
+	tokenFloat
+	tokenString
+	tokenBytes
+	tokenArray
+	tokenMap
+	tokenTime
+)
+
+// token is a scalar value or the header of a array or a map, n is the number
+// of elements, s refers to the data being decoded.
+type token struct {
+	kind tokenKind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	s    []byte
+	n    int
+	t    time.Time
+}
+
+type binaryReader interface {
+	readToken() (token, error)
+	remaining() int
+}
+
+var errTruncated = errors.New("encoded value is truncated")
+
+// binaryData is the data being decoded by a binaryReader.
+type binaryData struct {
+	data []byte
+	pos  int
+}
+
+func (r *binaryData) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *binaryData) read(n int) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, errTruncated
+	}
+	bs := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return bs, nil
+}
+
+// readUint reads a big endian integer of size bytes.
+func (r *binaryData) readUint(size int) (uint64, error) {
+	bs, err := r.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, b := range bs {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type binaryField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+type fieldsKey struct {
+	typ reflect.Type
+	tag string
+}
+
+var binaryFields sync.Map
+
+// structFields returns the encoded fields of typ, the name is read from tag or
+// the json tag, anonymous structs without a name are flattened.
+func structFields(typ reflect.Type, tag string) []binaryField {
+	key := fieldsKey{typ: typ, tag: tag}
+	if fields, ok := binaryFields.Load(key); ok {
+		return fields.([]binaryField)
+	}
+
+	var fields []binaryField
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		value, ok := f.Tag.Lookup(tag)
+		if !ok {
+			value = f.Tag.Get("json")
+		}
+		if value == "-" {
+			continue
+		}
+		opts := strings.Split(value, ",")
+		name := opts[0]
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, child := range structFields(f.Type, tag) {
+				child.index = append([]int{i}, child.index...)
+				fields = append(fields, child)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field := binaryField{name: name, index: []int{i}}
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				field.omitEmpty = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	binaryFields.Store(key, fields)
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+func encodeBinary(w binaryWriter, tag string, v reflect.Value) error {
+	if !v.IsValid() {
+		w.writeNil()
+		return nil
+	}
+	if v.Type() == timeType {
+		w.writeTime(v.Interface().(time.Time))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		return encodeBinary(w, tag, v.Elem())
+	case reflect.Bool:
+		w.writeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.writeUint(v.Uint())
+	case reflect.Float32:
+		w.writeFloat32(float32(v.Float()))
+	case reflect.Float64:
+		w.writeFloat64(v.Float())
+	case reflect.String:
+		w.writeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				w.writeBytes(v.Bytes())
+			} else {
+				bs := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(bs), v)
+				w.writeBytes(bs)
+			}
+			return nil
+		}
+		w.writeArrayHeader(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeBinary(w, tag, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		keys := v.MapKeys()
+		if v.Type().Key().Kind() == reflect.String {
+			sort.Slice(keys, func(i, j int) bool {
+				return keys[i].String() < keys[j].String()
+			})
+		}
+		w.writeMapHeader(len(keys))
+		for _, k := range keys {
+			if err := encodeBinary(w, tag, k); err != nil {
+				return err
+			}
+			if err := encodeBinary(w, tag, v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := structFields(v.Type(), tag)
+		values := make([]reflect.Value, len(fields))
+		var n int
+		for idx, f := range fields {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			values[idx] = fv
+			n++
+		}
+		w.writeMapHeader(n)
+		for idx, f := range fields {
+			if !values[idx].IsValid() {
+				continue
+			}
+			w.writeString(f.name)
+			if err := encodeBinary(w, tag, values[idx]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("type " + v.Type().String() + " can't be encoded")
+	}
+	return nil
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex without creating the embedded
+// pointers, ok is false if a embedded pointer is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+func decodeBinary(r binaryReader, tag string, value interface{}) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("value to decode must be a non-nil pointer")
+	}
+	if err := decodeBinaryValue(r, tag, v.Elem()); err != nil {
+		return err
+	}
+	if r.remaining() != 0 {
+		return errors.New("there are extra data after the value")
+	}
+	return nil
+}
+
+func decodeBinaryValue(r binaryReader, tag string, v reflect.Value) error {
+	tok, err := r.readToken()
+	if err != nil {
+		return err
+	}
+	return decodeToken(r, tag, tok, v)
+}
+
+func checkLength(r binaryReader, n int) error {
+	// every element is encoded in one byte at least.
+	if n > r.remaining() {
+		return errors.New("length of array or map is out of the data")
+	}
+	return nil
+}
+
+func decodeToken(r binaryReader, tag string, tok token, v reflect.Value) error {
+	if tok.kind == tokenNil {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeToken(r, tag, tok, v.Elem())
+	}
+	if v.Kind() == reflect.Interface {
+		if v.NumMethod() != 0 {
+			return errors.New("type " + v.Type().String() + " can't be decoded")
+		}
+		any, err := decodeAny(r, tok)
+		if err != nil {
+			return err
+		}
+		if any != nil {
+			v.Set(reflect.ValueOf(any))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	if v.Type() == timeType {
+		switch tok.kind {
+		case tokenTime:
+			v.Set(reflect.ValueOf(tok.t))
+		case tokenString:
+			t, err := time.Parse(time.RFC3339Nano, string(tok.s))
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(t))
+		default:
+			return mismatchError(tok, v)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if tok.kind != tokenBool {
+			return mismatchError(tok, v)
+		}
+		v.SetBool(tok.b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch tok.kind {
+		case tokenInt:
+			i = tok.i
+		case tokenUint:
+			if tok.u > math.MaxInt64 {
+				return overflowError(v)
+			}
+			i = int64(tok.u)
+		default:
+			return mismatchError(tok, v)
+		}
+		if v.OverflowInt(i) {
+			return overflowError(v)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch tok.kind {
+		case tokenUint:
+			u = tok.u
+		case tokenInt:
+			if tok.i < 0 {
+				return overflowError(v)
+			}
+			u = uint64(tok.i)
+		default:
+			return mismatchError(tok, v)
+		}
+		if v.OverflowUint(u) {
+			return overflowError(v)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch tok.kind {
+		case tokenFloat:
+			v.SetFloat(tok.f)
+		case tokenInt:
+			v.SetFloat(float64(tok.i))
+		case tokenUint:
+			v.SetFloat(float64(tok.u))
+		default:
+			return mismatchError(tok, v)
+		}
+	case reflect.String:
+		if tok.kind != tokenString && tok.kind != tokenBytes {
+			return mismatchError(tok, v)
+		}
+		v.SetString(string(tok.s))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (tok.kind == tokenBytes || tok.kind == tokenString) {
+			bs := reflect.MakeSlice(v.Type(), len(tok.s), len(tok.s))
+			reflect.Copy(bs, reflect.ValueOf(tok.s))
+			v.Set(bs)
+			return nil
+		}
+		if tok.kind != tokenArray {
+			return mismatchError(tok, v)
+		}
+		if err := checkLength(r, tok.n); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), tok.n, tok.n)
+		for i := 0; i < tok.n; i++ {
+			if err := decodeBinaryValue(r, tag, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (tok.kind == tokenBytes || tok.kind == tokenString) {
+			reflect.Copy(v, reflect.ValueOf(tok.s))
+			return nil
+		}
+		if tok.kind != tokenArray {
+			return mismatchError(tok, v)
+		}
+		for i := 0; i < tok.n; i++ {
+			if i < v.Len() {
+				if err := decodeBinaryValue(r, tag, v.Index(i)); err != nil {
+					return err
+				}
+			} else if err := skipBinaryValue(r); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if tok.kind != tokenMap {
+			return mismatchError(tok, v)
+		}
+		if err := checkLength(r, tok.n); err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), tok.n))
+		}
+		for i := 0; i < tok.n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := decodeBinaryValue(r, tag, key); err != nil {
+				return err
+			}
+			if !hashable(key) {
+				return errors.New("map key isn't comparable")
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeBinaryValue(r, tag, elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		if tok.kind != tokenMap {
+			return mismatchError(tok, v)
+		}
+		fields := structFields(v.Type(), tag)
+		for i := 0; i < tok.n; i++ {
+			key, err := r.readToken()
+			if err != nil {
+				return err
+			}
+			if key.kind != tokenString {
+				return errors.New("field name of " + v.Type().String() + " isn't a string")
+			}
+
+			var field *binaryField
+			for idx := range fields {
+				if fields[idx].name == string(key.s) {
+					field = &fields[idx]
+					break
+				}
+			}
+			if field == nil {
+				if err := skipBinaryValue(r); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := decodeBinaryValue(r, tag, settableField(v, field.index)); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("type " + v.Type().String() + " can't be decoded")
+	}
+	return nil
+}
+
+// settableField is reflect.Value.FieldByIndex which creates the nil embedded pointers.
+func settableField(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+func skipBinaryValue(r binaryReader) error {
+	tok, err := r.readToken()
+	if err != nil {
+		return err
+	}
+	_, err = decodeAny(r, tok)
+	return err
+}
+
+// decodeAny decodes a value into the generic types, maps whose keys are all
+// strings are decoded to map[string]interface{}.
+func decodeAny(r binaryReader, tok token) (interface{}, error) {
+	switch tok.kind {
+	case tokenNil:
+		return nil, nil
+	case tokenBool:
+		return tok.b, nil
+	case tokenInt:
+		return tok.i, nil
+	case tokenUint:
+		return tok.u, nil
+	case tokenFloat:
+		return tok.f, nil
+	case tokenString:
+		return string(tok.s), nil
+	case tokenBytes:
+		return append([]byte(nil), tok.s...), nil
+	case tokenTime:
+		return tok.t, nil
+	case tokenArray:
+		if err := checkLength(r, tok.n); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, tok.n)
+		for i := range values {
+			elem, err := r.readToken()
+			if err != nil {
+				return nil, err
+			}
+			if values[i], err = decodeAny(r, elem); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case tokenMap:
+		if err := checkLength(r, tok.n); err != nil {
+			return nil, err
+		}
+		keys := make([]interface{}, tok.n)
+		values := make([]interface{}, tok.n)
+		allStrings := true
+		for i := range keys {
+			for _, target := range []*interface{}{&keys[i], &values[i]} {
+				elem, err := r.readToken()
+				if err != nil {
+					return nil, err
+				}
+				if *target, err = decodeAny(r, elem); err != nil {
+					return nil, err
+				}
+			}
+			if _, ok := keys[i].(string); !ok {
+				allStrings = false
+			}
+		}
+		if allStrings {
+			m := make(map[string]interface{}, tok.n)
+			for i := range keys {
+				m[keys[i].(string)] = values[i]
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, tok.n)
+		for i := range keys {
+			if keys[i] != nil && !reflect.TypeOf(keys[i]).Comparable() {
+				return nil, errors.New("map key isn't comparable")
+			}
+			m[keys[i]] = values[i]
+		}
+		return m, nil
+	}
+	return nil, errors.New("token is unknown")
+}
+
+// hashable returns true if v can be a map key, the interfaces in it may hold
+// maps, slices or []byte which can't.
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return v.Type().Comparable()
+}
+
+func mismatchError(tok token, v reflect.Value) error {
+	names := []string{"nil", "bool", "int", "uint", "float", "string", "bytes", "array", "map", "time"}
+	return errors.New("value of " + names[tok.kind] + " can't be decoded into " + v.Type().String())
+}
+
+func overflowError(v reflect.Value) error {
+	return errors.New("value overflows " + v.Type().String())
+}
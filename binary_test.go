@@ -0,0 +1,182 @@
+package borm_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+type binaryBase struct {
+	ID int `json:"id"`
+}
+
+type binaryItem struct {
+	binaryBase
+	Name    string            `msgpack:"name" cbor:"n"`
+	Skipped string            `json:"-"`
+	Empty   string            `json:"empty,omitempty"`
+	Created time.Time         `json:"created"`
+	Tags    []string          `json:"tags"`
+	Attrs   map[string]int    `json:"attrs"`
+	Data    []byte            `json:"data"`
+	Ratio   float64           `json:"ratio"`
+	Child   *binaryItem       `json:"child,omitempty"`
+	Any     interface{}       `json:"any"`
+	Labels  map[string]string `json:"labels"`
+}
+
+func TestBinaryCodecs(t *testing.T) {
+	codecs := map[string][2]interface{}{
+		"msgpack": {borm.EncodeFunc(borm.MsgpackEncode), borm.DecodeFunc(borm.MsgpackDecode)},
+		"cbor":    {borm.EncodeFunc(borm.CBOREncode), borm.DecodeFunc(borm.CBORDecode)},
+	}
+
+	for name, codec := range codecs {
+		encode, decode := codec[0].(borm.EncodeFunc), codec[1].(borm.DecodeFunc)
+
+		expected := &binaryItem{
+			binaryBase: binaryBase{ID: -300},
+			Name:       "item",
+			Skipped:    "skipped",
+			Created:    time.Date(2017, 10, 1, 12, 30, 15, 123456789, time.UTC),
+			Tags:       []string{"a", "b"},
+			Attrs:      map[string]int{"x": 1, "y": 70000},
+			Data:       []byte{0, 1, 2},
+			Ratio:      0.25,
+			Child:      &binaryItem{Name: "child", Created: time.Unix(1500000000, 0).UTC()},
+			Any:        map[string]interface{}{"list": []interface{}{"s", true, nil}},
+		}
+		data, err := encode(expected)
+		if err != nil {
+			t.Fatalf("%s: Error encoding: %s", name, err)
+		}
+
+		result := &binaryItem{}
+		if err := decode(data, result); err != nil {
+			t.Fatalf("%s: Error decoding: %s", name, err)
+		}
+		expected.Skipped = ""
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("%s: Got %#v wanted %#v", name, result, expected)
+		}
+
+		var generic map[string]interface{}
+		if err := decode(data, &generic); err != nil {
+			t.Fatalf("%s: Error decoding: %s", name, err)
+		}
+		if _, ok := generic["empty"]; ok {
+			t.Fatalf("%s: empty field is encoded", name)
+		}
+		if _, ok := generic["created"].(time.Time); !ok {
+			t.Fatalf("%s: Got %#v wanted a time", name, generic["created"])
+		}
+
+		var small int8
+		if err := decode(data[:len(data)-1], result); err == nil {
+			t.Fatalf("%s: truncated data is decoded", name)
+		}
+		bs, _ := encode(300)
+		if err := decode(bs, &small); err == nil {
+			t.Fatalf("%s: overflow isn't detected", name)
+		}
+	}
+}
+
+func TestBinaryFormats(t *testing.T) {
+	// the expected data are from the examples of the specifications.
+	for _, test := range []struct {
+		encode   borm.EncodeFunc
+		value    interface{}
+		expected string
+	}{
+		{borm.MsgpackEncode, map[string]interface{}{"compact": true, "schema": 0}, "82a7636f6d70616374c3a6736368656d6100"},
+		{borm.MsgpackEncode, -33, "d0df"},
+		{borm.MsgpackEncode, uint16(256), "cd0100"},
+		{borm.MsgpackEncode, time.Unix(1, 0), "d6ff00000001"},
+		{borm.CBOREncode, 1000000, "1a000f4240"},
+		{borm.CBOREncode, -1000, "3903e7"},
+		{borm.CBOREncode, []interface{}{1, []int{2, 3}}, "8201820203"},
+		{borm.CBOREncode, map[string]string{"a": "A", "b": "B"}, "a26161614161626142"},
+		{borm.CBOREncode, []byte{1, 2, 3, 4}, "4401020304"},
+		{borm.CBOREncode, 1.1, "fb3ff199999999999a"},
+		{borm.CBOREncode, time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+	} {
+		data, err := test.encode(test.value)
+		if err != nil {
+			t.Fatalf("Error encoding %v: %s", test.value, err)
+		}
+		if hex.EncodeToString(data) != test.expected {
+			t.Fatalf("%v is encoded to %x wanted %s", test.value, data, test.expected)
+		}
+	}
+
+	var f float64
+	if err := borm.CBORDecode([]byte{0xf9, 0x3c, 0x00}, &f); err != nil || f != 1 {
+		t.Fatalf("Got %v, %v wanted 1", f, err)
+	}
+	var ts time.Time
+	if err := borm.CBORDecode([]byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, &ts); err != nil || ts.Unix() != 1363896240 {
+		t.Fatalf("Got %v, %v wanted 1363896240", ts, err)
+	}
+}
+
+func TestBinaryBucket(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", borm.MsgpackEncode, borm.MsgpackDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		expected := &ItemTest{Name: "item", Created: time.Now(), Tags: []string{"a"}}
+		if err := bkt.Insert("a", expected); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+		result := &ItemTest{}
+		if err := bkt.Get("a", result); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if !expected.equal(result) {
+			t.Fatalf("Got %v wanted %v", result, expected)
+		}
+
+		gob, _ := borm.DefaultEncode(expected)
+		data, _ := borm.MsgpackEncode(expected)
+		if len(data) >= len(gob) {
+			t.Fatalf("msgpack value is %d bytes, gob is %d bytes", len(data), len(gob))
+		}
+	})
+}
+
+func TestBinaryUnhashableKeys(t *testing.T) {
+	type withMap struct {
+		M map[interface{}]interface{}
+	}
+
+	// the keys are maps, they can't be keys of a go map.
+	for name, decode := range map[string]borm.DecodeFunc{
+		"msgpack": borm.MsgpackDecode,
+		"cbor":    borm.CBORDecode,
+	} {
+		data := []byte{0x81, 0x81, 0x01, 0x02, 0x03}
+		wrapped := []byte{0x81, 0xa1, 'M', 0x81, 0x81, 0x01, 0x02, 0x03}
+		if name == "cbor" {
+			data = []byte{0xa1, 0xa1, 0x01, 0x02, 0x03}
+			wrapped = []byte{0xa1, 0x61, 'M', 0xa1, 0xa1, 0x01, 0x02, 0x03}
+		}
+
+		var m map[interface{}]interface{}
+		if err := decode(data, &m); err == nil {
+			t.Fatalf("%s: a map key is decoded into %v", name, m)
+		}
+		var w withMap
+		if err := decode(wrapped, &w); err == nil {
+			t.Fatalf("%s: a map key is decoded into %v", name, w.M)
+		}
+		var any interface{}
+		if err := decode(data, &any); err == nil {
+			t.Fatalf("%s: a map key is decoded into %v", name, any)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package borm
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// bloomFilter is a bloom filter of the keys of a sealed shard, it is stored in a
+// hidden file alongside the shard so that TSEngine.Get skips the shards which
+// don't contain a id without opening them.
+type bloomFilter struct {
+	k    uint32
+	bits []uint64
+}
+
+// newBloomFilter creates a filter for n keys with a false positive rate of 1%.
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(0.01) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		k:    uint32(k),
+		bits: make([]uint64, (int(m)+63)/64),
+	}
+}
+
+func (f *bloomFilter) locations(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum >> 32
+}
+
+func (f *bloomFilter) add(key []byte) {
+	h1, h2 := f.locations(key)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		idx := (h1 + i*h2) % m
+		f.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+// test returns false if key is absolutely not in the filter.
+func (f *bloomFilter) test(key []byte) bool {
+	h1, h2 := f.locations(key)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		idx := (h1 + i*h2) % m
+		if f.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) write(w io.Writer) error {
+	bs := make([]byte, 8+8*len(f.bits))
+	binary.BigEndian.PutUint32(bs, f.k)
+	binary.BigEndian.PutUint32(bs[4:], uint32(len(f.bits)))
+	for idx, word := range f.bits {
+		binary.BigEndian.PutUint64(bs[8+8*idx:], word)
+	}
+	_, err := w.Write(bs)
+	return err
+}
+
+func readBloomFilter(file string) (*bloomFilter, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) < 8 {
+		return nil, errors.New("bloom filter '" + file + "' is invalid")
+	}
+	f := &bloomFilter{
+		k:    binary.BigEndian.Uint32(bs),
+		bits: make([]uint64, binary.BigEndian.Uint32(bs[4:])),
+	}
+	if f.k == 0 || len(f.bits) == 0 || len(bs) != 8+8*len(f.bits) {
+		return nil, errors.New("bloom filter '" + file + "' is invalid")
+	}
+	for idx := range f.bits {
+		f.bits[idx] = binary.BigEndian.Uint64(bs[8+8*idx:])
+	}
+	return f, nil
+}
+
+func bloomFile(shardFile string) string {
+	return filepath.Join(filepath.Dir(shardFile), "."+filepath.Base(shardFile)+".bloom")
+}
+
+// buildBloom writes the bloom filter of the keys in bkt for the shard of file.
+func (db *TSEngine) buildBloom(file string, bkt *Bucket) error {
+	var f *bloomFilter
+	err := bkt.ForEach(func(it *Iterator) error {
+		f = newBloomFilter(it.Cursor.Bucket().Stats().KeyN)
+		for it.Next() {
+			f.add(it.Key())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	delete(db.blooms, file)
+	return writeFileAtomic(bloomFile(file), f.write)
+}
+
+// bloom returns the bloom filter of the shard of file, it is nil if the shard
+// is open for writing or the filter doesn't exist.
+func (db *TSEngine) bloom(file string) *bloomFilter {
+	if db.writers[file] != nil {
+		return nil
+	}
+	if f, ok := db.blooms[file]; ok {
+		return f
+	}
+
+	f, err := readBloomFilter(bloomFile(file))
+	if err != nil {
+		f = nil
+	}
+	if db.blooms == nil {
+		db.blooms = map[string]*bloomFilter{}
+	}
+	db.blooms[file] = f
+	return f
+}
+
+// removeBloom removes the bloom filter of a shard which is removed or rewritten.
+func (db *TSEngine) removeBloom(file string) {
+	delete(db.blooms, file)
+	os.Remove(bloomFile(file))
+}
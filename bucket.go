@@ -1,6 +1,9 @@
 package borm
 
-import "time"
+import (
+	"reflect"
+	"time"
+)
 
 // Bucket is the Interface to implement to skip reflect calls on all data passed into the bolthold
 type Bucket struct {
@@ -9,6 +12,8 @@ type Bucket struct {
 	name   []byte
 	encode EncodeFunc
 	decode DecodeFunc
+
+	valueType reflect.Type
 }
 
 // Record is a data record
@@ -0,0 +1,533 @@
+package borm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	walName     = ".wal"
+	walLockName = ".wal.lock"
+)
+
+// Durability is the fsync mode of the write-ahead log of the write buffer
+type Durability int
+
+const (
+	// DurabilitySync syncs the log on every write.
+	DurabilitySync Durability = iota
+	// DurabilityInterval syncs the log every SyncInterval, the writes of the
+	// last interval may be lost by a crash of the machine.
+	DurabilityInterval
+	// DurabilityNone never syncs the log, the writes survive a crash of the
+	// process but may be lost by a crash of the machine.
+	DurabilityNone
+)
+
+// BufferOptions describe the write buffer of a TSEngine
+type BufferOptions struct {
+	// MaxSize flushes the buffer when it has MaxSize writes, defaults to 10000.
+	MaxSize int
+	// Interval flushes the buffer periodically, defaults to one second.
+	Interval time.Duration
+	// WAL appends the writes to a log in the base path, the log of a engine
+	// which isn't closed cleanly is replayed when the base path is opened again.
+	WAL          bool
+	Durability   Durability
+	SyncInterval time.Duration
+	// OnError is called with the errors of the background flushes, errors are
+	// logged if it is nil. The writes rejected by the late write policy are
+	// reported as ErrLateWrite.
+	OnError func(err error)
+}
+
+type bufferedWrite struct {
+	t     time.Time
+	key   string
+	value []byte
+}
+
+type writeBuffer struct {
+	opts    BufferOptions
+	entries []bufferedWrite
+	wal     *os.File
+	walLock *bolt.DB
+	// flushing is true while a flush writes the shards, the other flushes wait
+	// for it so that the log keeps the writes which aren't committed yet.
+	flushing bool
+	// rejected is true if some writes are rejected by the late write policy
+	// since the last Flush.
+	rejected bool
+	closed   chan struct{}
+	done     chan struct{}
+}
+
+// EnableBuffer buffers the writes of Put in memory, they are written to the
+// shards by size or interval and before the reads of the engine.
+func (db *TSEngine) EnableBuffer(opts BufferOptions) error {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 10000
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = 100 * time.Millisecond
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.buffer != nil {
+		return errors.New("buffer is already enabled")
+	}
+	b := &writeBuffer{
+		opts:   opts,
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opts.WAL {
+		lock, err := lockWAL(db.basePath)
+		if err != nil {
+			if err == bolt.ErrTimeout {
+				return errors.New("the write-ahead log is used by another engine")
+			}
+			return err
+		}
+		// a log left since the engine is opened is replayed first.
+		if err := db.replayWAL(); err != nil {
+			lock.Close()
+			return err
+		}
+		wal, err := os.OpenFile(filepath.Join(db.basePath, walName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+		if err != nil {
+			lock.Close()
+			return err
+		}
+		b.wal, b.walLock = wal, lock
+	}
+	db.buffer = b
+
+	go db.runBuffer(b)
+	return nil
+}
+
+// DisableBuffer flushes the buffer and stops buffering the writes.
+func (db *TSEngine) DisableBuffer() error {
+	db.stopBufferWorker()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.closeBuffer()
+}
+
+// Flush writes the buffered writes to the shards. It returns ErrLateWrite if
+// some writes are rejected by the late write policy since the last Flush, the
+// flushes before the reads don't return it.
+func (db *TSEngine) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushBuffer(); err != nil {
+		return err
+	}
+	if b := db.buffer; b != nil && b.rejected {
+		b.rejected = false
+		return ErrLateWrite
+	}
+	return nil
+}
+
+func (db *TSEngine) runBuffer(b *writeBuffer) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.opts.Interval)
+	defer ticker.Stop()
+
+	var syncs <-chan time.Time
+	if b.wal != nil && b.opts.Durability == DurabilityInterval {
+		syncTicker := time.NewTicker(b.opts.SyncInterval)
+		defer syncTicker.Stop()
+		syncs = syncTicker.C
+	}
+
+	for {
+		var err error
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+			err = db.Flush()
+		case <-syncs:
+			db.mu.Lock()
+			err = b.wal.Sync()
+			db.mu.Unlock()
+		}
+		if err == nil {
+			continue
+		}
+		if b.opts.OnError != nil {
+			b.opts.OnError(err)
+		} else {
+			log.Printf("engine failed to flush the write buffer: %s", err)
+		}
+	}
+}
+
+func (db *TSEngine) stopBufferWorker() {
+	db.mu.Lock()
+	b := db.buffer
+	db.mu.Unlock()
+
+	if b != nil && b.closed != nil {
+		select {
+		case <-b.closed:
+		default:
+			close(b.closed)
+		}
+		<-b.done
+	}
+}
+
+// closeBuffer flushes the buffer and removes the log, the log is kept if the
+// flush is failed so that the writes are replayed by the next engine.
+func (db *TSEngine) closeBuffer() error {
+	b := db.buffer
+	if b == nil {
+		return nil
+	}
+	err := db.flushBuffer()
+	db.buffer = nil
+	if b.wal == nil {
+		return err
+	}
+	if e := b.wal.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Remove(b.wal.Name())
+	}
+	if e := b.walLock.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// Put writes value with key into the shard of t, it is buffered if the buffer
+// is enabled. The value is encoded by DefaultEncode.
+func (db *TSEngine) Put(t time.Time, key string, value interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.buffer == nil {
+		return db.write(t, func(bkt *Bucket) error {
+			return bkt.Upsert(key, value)
+		})
+	}
+
+	data, err := DefaultEncode(value)
+	if err != nil {
+		return err
+	}
+	entry := bufferedWrite{t: t, key: key, value: data}
+
+	if b := db.buffer; b.wal != nil {
+		if _, err := b.wal.Write(encodeWALRecord(entry)); err != nil {
+			return err
+		}
+		if b.opts.Durability == DurabilitySync {
+			if err := b.wal.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	db.buffer.entries = append(db.buffer.entries, entry)
+	if len(db.buffer.entries) >= db.buffer.opts.MaxSize {
+		return db.flushBuffer()
+	}
+	return nil
+}
+
+// flushBuffer writes the buffered writes of each shard in one transaction. The
+// writes of the failed shards are kept in the buffer and the log, the writes
+// rejected by the late write policy are dropped and reported by Flush.
+func (db *TSEngine) flushBuffer() error {
+	b := db.buffer
+	for b != nil && b.flushing {
+		db.idle.Wait()
+		b = db.buffer
+	}
+	if b == nil || len(b.entries) == 0 {
+		return nil
+	}
+
+	// the lock is released while a shard read by a callback is waited for,
+	// so the writes buffered meanwhile are kept.
+	pending := b.entries
+	b.entries = nil
+	b.flushing = true
+	defer func() {
+		b.flushing = false
+		db.idle.Broadcast()
+	}()
+
+	var files []string
+	groups := map[string][]bufferedWrite{}
+	for _, entry := range pending {
+		file := db.shardFile(entry.t)
+		if _, ok := groups[file]; !ok {
+			files = append(files, file)
+		}
+		groups[file] = append(groups[file], entry)
+	}
+
+	var remaining []bufferedWrite
+	var err error
+	for _, file := range files {
+		entries := groups[file]
+		e := db.writeBuffered(entries)
+		if e == nil {
+			continue
+		}
+		if e == ErrLateWrite {
+			b.rejected = true
+			continue
+		}
+		remaining = append(remaining, entries...)
+		if err == nil {
+			err = e
+		}
+	}
+	b.entries = append(remaining, b.entries...)
+
+	// the flushes are serialized, so the log is replaced by the writes which
+	// are kept and the writes buffered meanwhile.
+	if b.wal != nil {
+		if e := rewriteWAL(b.wal, b.entries); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// writeBuffered writes the buffered writes of a shard in one transaction, the
+// late write policy is applied to each write. It returns ErrLateWrite if some
+// writes are rejected, the others are written.
+func (db *TSEngine) writeBuffered(entries []bufferedWrite) error {
+	last := entries[0].t
+	for _, entry := range entries[1:] {
+		if entry.t.After(last) {
+			last = entry.t
+		}
+	}
+	file := db.waitWritable(last)
+
+	var accepted []bufferedWrite
+	var rejected bool
+	late := true
+	for _, entry := range entries {
+		if !db.trackLateness(entry.t) {
+			accepted = append(accepted, entry)
+			late = false
+			continue
+		}
+		if db.late.Reject {
+			db.lateStats.Rejected++
+			rejected = true
+			continue
+		}
+		db.lateStats.Redirected++
+		accepted = append(accepted, entry)
+	}
+	if len(accepted) == 0 {
+		return ErrLateWrite
+	}
+
+	w, once, err := db.openWriter(file, last, late)
+	if err != nil {
+		return err
+	}
+	err = w.bkt.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(w.bkt.name)
+		if bucket == nil {
+			return ErrBucketNotFound
+		}
+		for _, entry := range accepted {
+			if err := bucket.Put([]byte(entry.key), entry.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if once {
+		if e := db.closeWriter(w); err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := db.advance(last); err != nil {
+		return err
+	}
+	if rejected {
+		return ErrLateWrite
+	}
+	return nil
+}
+
+// rewriteWAL replaces the log with entries.
+func rewriteWAL(wal *os.File, entries []bufferedWrite) error {
+	if err := wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := wal.Write(encodeWALRecord(entry)); err != nil {
+			return err
+		}
+	}
+	return wal.Sync()
+}
+
+// encodeWALRecord encodes a record of the log, it is the length and the CRC32C
+// of the payload and the payload, which is the time, the key and the value.
+func encodeWALRecord(entry bufferedWrite) []byte {
+	payload := make([]byte, 8+binary.MaxVarintLen64+len(entry.key)+len(entry.value))
+	binary.BigEndian.PutUint64(payload, uint64(entry.t.UnixNano()))
+	n := 8 + binary.PutUvarint(payload[8:], uint64(len(entry.key)))
+	n += copy(payload[n:], entry.key)
+	n += copy(payload[n:], entry.value)
+	payload = payload[:n]
+
+	record := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, castagnoli))
+	copy(record[8:], payload)
+	return record
+}
+
+// maxWALRecord is the max length of the payload of a record, it is the time and
+// the largest key and value of bolt.
+const maxWALRecord = 8 + binary.MaxVarintLen64 + bolt.MaxKeySize + bolt.MaxValueSize
+
+// readWAL reads the records of the log, it stops at the first torn or corrupt
+// record, which is the last write before a crash.
+func readWAL(file string) ([]bufferedWrite, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []bufferedWrite
+	r := bufio.NewReader(f)
+	var header [8]byte
+	remaining := stat.Size()
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+		remaining -= int64(len(header))
+
+		// a damaged length is a torn record, it isn't allocated.
+		length := int64(binary.BigEndian.Uint32(header[:]))
+		if length > maxWALRecord || length > remaining {
+			return entries, nil
+		}
+		remaining -= length
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+		if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(header[4:]) || len(payload) < 8 {
+			return entries, nil
+		}
+
+		keyLen, n := binary.Uvarint(payload[8:])
+		if n <= 0 || uint64(len(payload)-8-n) < keyLen {
+			return entries, nil
+		}
+		start := 8 + n
+		entries = append(entries, bufferedWrite{
+			t:     time.Unix(0, int64(binary.BigEndian.Uint64(payload))),
+			key:   string(payload[start : start+int(keyLen)]),
+			value: payload[start+int(keyLen):],
+		})
+	}
+}
+
+// lockWAL takes the lock of the log, it is held by the engine which writes the
+// log, so that the other engines opened in the base path leave the log alone.
+// It returns bolt.ErrTimeout if the lock is held.
+func lockWAL(basePath string) (*bolt.DB, error) {
+	return bolt.Open(filepath.Join(basePath, walLockName), 0666, &bolt.Options{Timeout: time.Millisecond})
+}
+
+// recoverWAL replays the log left by a engine which isn't closed cleanly, the
+// log of a running engine is skipped.
+func (db *TSEngine) recoverWAL() error {
+	if _, err := os.Stat(filepath.Join(db.basePath, walName)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	lock, err := lockWAL(db.basePath)
+	if err != nil {
+		if err == bolt.ErrTimeout {
+			return nil
+		}
+		return err
+	}
+
+	db.mu.Lock()
+	err = db.replayWAL()
+	db.mu.Unlock()
+	if e := lock.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// replayWAL writes the records of a log left by a crash to the shards.
+func (db *TSEngine) replayWAL() error {
+	file := filepath.Join(db.basePath, walName)
+	entries, err := readWAL(file)
+	if err != nil || entries == nil {
+		return err
+	}
+
+	db.buffer = &writeBuffer{entries: entries}
+	err = db.flushBuffer()
+	db.buffer = nil
+	if err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
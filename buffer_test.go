@@ -0,0 +1,341 @@
+package borm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestWriteBuffer(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		if err := db.EnableBuffer(borm.BufferOptions{MaxSize: 100, Interval: time.Hour}); err != nil {
+			t.Fatalf("Error enabling buffer: %s", err)
+		}
+
+		start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 30; i++ {
+			at := start.Add(time.Duration(i) * 4 * time.Hour)
+			if err := db.Put(at, borm.CreateID(at, 0), &sample{Value: float64(i)}); err != nil {
+				t.Fatalf("Error putting: %s", err)
+			}
+		}
+		if shards := db.Shards(); len(shards) != 0 {
+			t.Fatalf("%d shards are written before flushed", len(shards))
+		}
+
+		// the query flushes the buffer first.
+		var count int
+		err := db.Query(start, start.AddDate(0, 0, 6), func(it *borm.Iterator) error {
+			for it.Next() {
+				var s sample
+				if err := it.Read(&s); err != nil {
+					return err
+				}
+				if s.Value != float64(count) {
+					t.Fatalf("Got %v wanted %d", s.Value, count)
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 30 {
+			t.Fatalf("Got %d records wanted 30", count)
+		}
+		if shards := db.Shards(); len(shards) != 6 {
+			t.Fatalf("Got %d shards wanted 6", len(shards))
+		}
+
+		// the buffer is flushed by size.
+		for i := 0; i < 100; i++ {
+			at := start.AddDate(0, 0, 10).Add(time.Duration(i) * time.Second)
+			if err := db.Put(at, borm.CreateID(at, 0), &sample{Value: float64(i)}); err != nil {
+				t.Fatalf("Error putting: %s", err)
+			}
+		}
+		if shards := db.Shards(); len(shards) != 7 {
+			t.Fatalf("Got %d shards wanted 7", len(shards))
+		}
+
+		if err := db.DisableBuffer(); err != nil {
+			t.Fatalf("Error disabling buffer: %s", err)
+		}
+	})
+}
+
+func TestWALReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-wal-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatalf("Error opening: %s", err)
+	}
+	defer db.Close()
+
+	err = db.EnableBuffer(borm.BufferOptions{Interval: time.Hour, WAL: true, Durability: borm.DurabilitySync})
+	if err != nil {
+		t.Fatalf("Error enabling buffer: %s", err)
+	}
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		at := start.Add(time.Duration(i) * 6 * time.Hour)
+		if err := db.Put(at, borm.CreateID(at, 0), &sample{Value: float64(i)}); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+	}
+
+	// a crash is simulated by opening a copy of the log with a torn record.
+	wal, err := ioutil.ReadFile(filepath.Join(dir, "a", ".wal"))
+	if err != nil {
+		t.Fatalf("Error reading log: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the length of the torn record is damaged too, it isn't allocated.
+	torn := append(wal, 0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0)
+	torn = append(torn, wal[8:20]...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "b", ".wal"), torn, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := borm.OpenTS(filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatalf("Error opening: %s", err)
+	}
+	defer replayed.Close()
+	if _, err := os.Stat(filepath.Join(dir, "b", ".wal")); !os.IsNotExist(err) {
+		t.Fatalf("log isn't removed after replayed: %v", err)
+	}
+
+	var count int
+	err = replayed.Query(start, start.AddDate(0, 0, 3), func(it *borm.Iterator) error {
+		for it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if count != 10 {
+		t.Fatalf("Got %d records wanted 10", count)
+	}
+	if err := replayed.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", ".wal")); !os.IsNotExist(err) {
+		t.Fatalf("log isn't removed after closed: %v", err)
+	}
+}
+
+func TestWALOfRunningEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-wal-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening: %s", err)
+	}
+	defer db.Close()
+
+	err = db.EnableBuffer(borm.BufferOptions{Interval: time.Hour, WAL: true, Durability: borm.DurabilitySync})
+	if err != nil {
+		t.Fatalf("Error enabling buffer: %s", err)
+	}
+	at := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.Put(at, borm.CreateID(at, 0), &sample{Value: 1}); err != nil {
+		t.Fatalf("Error putting: %s", err)
+	}
+
+	// a engine opened to read the same path leaves the log alone.
+	reader, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening: %s", err)
+	}
+	if err := reader.EnableBuffer(borm.BufferOptions{WAL: true}); err == nil {
+		t.Fatal("log of the running engine is taken")
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".wal")); err != nil {
+		t.Fatalf("log of the running engine is removed: %v", err)
+	}
+
+	if err := db.Put(at.Add(time.Hour), borm.CreateID(at.Add(time.Hour), 0), &sample{Value: 2}); err != nil {
+		t.Fatalf("Error putting: %s", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	var count int
+	err = db.Query(at, at.Add(2*time.Hour), func(it *borm.Iterator) error {
+		for it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if count != 2 {
+		t.Fatalf("Got %d records wanted 2", count)
+	}
+}
+
+func TestFlushKeepsLogOfPendingWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-wal-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening: %s", err)
+	}
+	defer db.Close()
+
+	// the shard of day is closed when the shard of two days later is written.
+	day := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	later := day.AddDate(0, 0, 2)
+	for _, at := range []time.Time{day, later} {
+		if err := db.Put(at, borm.CreateID(at, 0), &sample{Value: 0}); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+	}
+
+	err = db.EnableBuffer(borm.BufferOptions{MaxSize: 2, Interval: time.Hour, WAL: true, Durability: borm.DurabilitySync})
+	if err != nil {
+		t.Fatalf("Error enabling buffer: %s", err)
+	}
+	putTwo := func(start time.Time, done chan<- error) {
+		for i := 1; i <= 2; i++ {
+			at := start.Add(time.Duration(i) * time.Minute)
+			if err := db.Put(at, borm.CreateID(at, 0), &sample{Value: float64(i)}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}
+
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	readDone := make(chan error, 1)
+	go func() {
+		readDone <- db.Read(day, day.Add(time.Hour), func(bkt *borm.Bucket) error {
+			close(reading)
+			<-release
+			return nil
+		})
+	}()
+	<-reading
+
+	// the flush of the writes of day waits for the read, the flush of the
+	// writes of later waits for it.
+	dayDone := make(chan error, 1)
+	go putTwo(day, dayDone)
+	time.Sleep(50 * time.Millisecond)
+	laterDone := make(chan error, 1)
+	go putTwo(later, laterDone)
+	time.Sleep(50 * time.Millisecond)
+
+	fi, err := os.Stat(filepath.Join(dir, ".wal"))
+	if err != nil {
+		t.Fatalf("Error reading log: %s", err)
+	}
+	if fi.Size() == 0 {
+		t.Fatal("log of the pending writes is truncated")
+	}
+
+	close(release)
+	for _, done := range []chan error{readDone, dayDone, laterDone} {
+		if err := <-done; err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+	var count int
+	err = db.Query(day, later.AddDate(0, 0, 1), func(it *borm.Iterator) error {
+		for it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if count != 6 {
+		t.Fatalf("Got %d records wanted 6", count)
+	}
+}
+
+func TestBufferedLateWrites(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		if err := db.SetLateWritePolicy(borm.LateWritePolicy{Window: 2 * time.Hour, Reject: true}); err != nil {
+			t.Fatalf("Error setting policy: %s", err)
+		}
+		today := time.Date(2017, 10, 2, 0, 0, 0, 0, time.UTC)
+		if err := db.Put(today.Add(3*time.Hour), borm.CreateID(today.Add(3*time.Hour), 0), &sample{Value: 1}); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+
+		if err := db.EnableBuffer(borm.BufferOptions{Interval: time.Hour}); err != nil {
+			t.Fatalf("Error enabling buffer: %s", err)
+		}
+		for _, at := range []time.Time{
+			today.Add(-time.Hour),
+			today.Add(-3 * time.Hour),
+			today.Add(-2 * time.Hour),
+			today.Add(time.Hour),
+		} {
+			if err := db.Put(at, borm.CreateID(at, 0), &sample{Value: 1}); err != nil {
+				t.Fatalf("Error putting: %s", err)
+			}
+		}
+		// the query flushes the buffer and doesn't fail by the rejected writes.
+		var count int
+		err := db.Query(today.Add(-24*time.Hour), today.Add(24*time.Hour), func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("Got %d records wanted 2", count)
+		}
+
+		// every write of the shard of yesterday is rejected.
+		stats := db.LateWriteStats()
+		if stats.OutOfOrder != 4 || stats.Late != 3 || stats.Rejected != 3 || stats.MaxLateness != 6*time.Hour {
+			t.Fatalf("Got %#v", stats)
+		}
+		if err := db.Flush(); err != borm.ErrLateWrite {
+			t.Fatalf("Flush didn't fail! Expected %s got %v", borm.ErrLateWrite, err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Error flushing: %s", err)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package borm
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// CBOREncode is a encoding func for borm (CBOR, RFC 7049), the fields of structs
+// are named by the cbor or the json tag, time.Time is a RFC 3339 string of tag 0.
+func CBOREncode(value interface{}) ([]byte, error) {
+	w := &cborWriter{}
+	if err := encodeBinary(w, "cbor", reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// CBORDecode is a decoding func for borm (CBOR), it reads times of tag 0 and
+// tag 1, the other tags are ignored.
+func CBORDecode(data []byte, value interface{}) error {
+	return decodeBinary(&cborReader{binaryData{data: data}}, "cbor", value)
+}
+
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborString = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+type cborWriter struct {
+	buf     bytes.Buffer
+	scratch [9]byte
+}
+
+// writeHead writes the major type and the argument u in its smallest format.
+func (w *cborWriter) writeHead(major byte, u uint64) {
+	major <<= 5
+	switch {
+	case u < 24:
+		w.buf.WriteByte(major | byte(u))
+	case u <= math.MaxUint8:
+		w.buf.Write([]byte{major | 24, byte(u)})
+	case u <= math.MaxUint16:
+		w.writeRaw(major|25, 2, u)
+	case u <= math.MaxUint32:
+		w.writeRaw(major|26, 4, u)
+	default:
+		w.writeRaw(major|27, 8, u)
+	}
+}
+
+func (w *cborWriter) writeRaw(code byte, size int, u uint64) {
+	w.scratch[0] = code
+	for i := size; i >= 1; i-- {
+		w.scratch[i] = byte(u)
+		u >>= 8
+	}
+	w.buf.Write(w.scratch[:1+size])
+}
+
+func (w *cborWriter) writeNil() {
+	w.buf.WriteByte(cborSimple<<5 | 22)
+}
+
+func (w *cborWriter) writeBool(b bool) {
+	if b {
+		w.buf.WriteByte(cborSimple<<5 | 21)
+	} else {
+		w.buf.WriteByte(cborSimple<<5 | 20)
+	}
+}
+
+func (w *cborWriter) writeInt(i int64) {
+	if i >= 0 {
+		w.writeHead(cborUint, uint64(i))
+	} else {
+		w.writeHead(cborNegInt, uint64(^i))
+	}
+}
+
+func (w *cborWriter) writeUint(u uint64) {
+	w.writeHead(cborUint, u)
+}
+
+func (w *cborWriter) writeFloat32(f float32) {
+	w.writeRaw(cborSimple<<5|26, 4, uint64(math.Float32bits(f)))
+}
+
+func (w *cborWriter) writeFloat64(f float64) {
+	w.writeRaw(cborSimple<<5|27, 8, math.Float64bits(f))
+}
+
+func (w *cborWriter) writeString(s string) {
+	w.writeHead(cborString, uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *cborWriter) writeBytes(bs []byte) {
+	w.writeHead(cborBytes, uint64(len(bs)))
+	w.buf.Write(bs)
+}
+
+func (w *cborWriter) writeArrayHeader(n int) {
+	w.writeHead(cborArray, uint64(n))
+}
+
+func (w *cborWriter) writeMapHeader(n int) {
+	w.writeHead(cborMap, uint64(n))
+}
+
+func (w *cborWriter) writeTime(t time.Time) {
+	w.writeHead(cborTag, 0)
+	w.writeString(t.Format(time.RFC3339Nano))
+}
+
+type cborReader struct {
+	binaryData
+}
+
+func (r *cborReader) readHead() (major byte, info byte, u uint64, err error) {
+	bs, err := r.read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = bs[0]>>5, bs[0]&0x1f
+	switch {
+	case info < 24:
+		u = uint64(info)
+	case info <= 27:
+		u, err = r.readUint(1 << (info - 24))
+	case info == 31:
+		err = errors.New("cbor indefinite length isn't supported")
+	default:
+		err = errors.New("cbor additional information " + strconv.Itoa(int(info)) + " is invalid")
+	}
+	return major, info, u, err
+}
+
+func (r *cborReader) readToken() (token, error) {
+	major, info, u, err := r.readHead()
+	if err != nil {
+		return token{}, err
+	}
+
+	switch major {
+	case cborUint:
+		return token{kind: tokenUint, u: u}, nil
+	case cborNegInt:
+		if u > math.MaxInt64 {
+			return token{}, errors.New("cbor negative integer overflows int64")
+		}
+		return token{kind: tokenInt, i: ^int64(u)}, nil
+	case cborBytes, cborString:
+		if u > uint64(r.remaining()) {
+			return token{}, errTruncated
+		}
+		s, err := r.read(int(u))
+		if major == cborBytes {
+			return token{kind: tokenBytes, s: s}, err
+		}
+		return token{kind: tokenString, s: s}, err
+	case cborArray, cborMap:
+		if u > uint64(r.remaining()) {
+			return token{}, errTruncated
+		}
+		if major == cborArray {
+			return token{kind: tokenArray, n: int(u)}, nil
+		}
+		return token{kind: tokenMap, n: int(u)}, nil
+	case cborTag:
+		return r.readTag(u)
+	}
+
+	switch info {
+	case 20, 21:
+		return token{kind: tokenBool, b: info == 21}, nil
+	case 22, 23:
+		return token{kind: tokenNil}, nil
+	case 25:
+		return token{kind: tokenFloat, f: float16ToFloat64(uint16(u))}, nil
+	case 26:
+		return token{kind: tokenFloat, f: float64(math.Float32frombits(uint32(u)))}, nil
+	case 27:
+		return token{kind: tokenFloat, f: math.Float64frombits(u)}, nil
+	}
+	return token{}, errors.New("cbor simple value " + strconv.FormatUint(u, 10) + " is unknown")
+}
+
+func (r *cborReader) readTag(tag uint64) (token, error) {
+	tok, err := r.readToken()
+	if err != nil {
+		return token{}, err
+	}
+
+	switch tag {
+	case 0:
+		if tok.kind != tokenString {
+			return token{}, errors.New("cbor time of tag 0 isn't a string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(tok.s))
+		return token{kind: tokenTime, t: t}, err
+	case 1:
+		switch tok.kind {
+		case tokenUint:
+			return token{kind: tokenTime, t: time.Unix(int64(tok.u), 0).UTC()}, nil
+		case tokenInt:
+			return token{kind: tokenTime, t: time.Unix(tok.i, 0).UTC()}, nil
+		case tokenFloat:
+			sec, frac := math.Modf(tok.f)
+			return token{kind: tokenTime, t: time.Unix(int64(sec), int64(frac*1e9)).UTC()}, nil
+		}
+		return token{}, errors.New("cbor time of tag 1 isn't a number")
+	}
+	return tok, nil
+}
+
+func float16ToFloat64(h uint16) float64 {
+	exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
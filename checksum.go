@@ -0,0 +1,224 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/boltdb/bolt"
+)
+
+// checksumBucket lists the buckets whose values have checksums, the value of a
+// bucket is checksumDone, or checksumPartial followed by the last key rewritten
+// by AddChecksums.
+const checksumBucket = "_checksums"
+
+const (
+	checksumDone    = 'd'
+	checksumPartial = 'p'
+)
+
+// ErrCorrupt is the cause of a CorruptError
+var ErrCorrupt = errors.New("The value is corrupt")
+
+// CorruptError is returned when the checksum of a value doesn't match, Bucket
+// and Key are set when the value is read from a bucket.
+type CorruptError struct {
+	Bucket string
+	Key    string
+}
+
+func (e *CorruptError) Error() string {
+	if e.Bucket == "" && e.Key == "" {
+		return ErrCorrupt.Error()
+	}
+	return "value of '" + e.Key + "' in bucket '" + e.Bucket + "' is corrupt"
+}
+
+// Unwrap returns ErrCorrupt
+func (e *CorruptError) Unwrap() error {
+	return ErrCorrupt
+}
+
+// IsCorrupt returns true if err is or wraps a CorruptError
+func IsCorrupt(err error) bool {
+	var e *CorruptError
+	return errors.As(err, &e)
+}
+
+const checksumSize = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumEncode wraps encode to append the CRC32C of every value, the values are
+// verified by ChecksumDecode and Store.Verify.
+func ChecksumEncode(encode EncodeFunc) EncodeFunc {
+	if encode == nil {
+		encode = DefaultEncode
+	}
+	return func(value interface{}) ([]byte, error) {
+		data, err := encode(value)
+		if err != nil {
+			return nil, err
+		}
+		return appendChecksum(data), nil
+	}
+}
+
+// ChecksumDecode wraps decode to verify the CRC32C of the values written by
+// ChecksumEncode, it returns a CorruptError if the checksum doesn't match. Every
+// value must have a checksum, the values of a existing bucket are rewritten by
+// AddChecksums before the bucket is read with ChecksumDecode.
+func ChecksumDecode(decode DecodeFunc) DecodeFunc {
+	if decode == nil {
+		decode = DefaultDecode
+	}
+	return func(data []byte, value interface{}) error {
+		plain, err := verifyChecksum(data)
+		if err != nil {
+			return err
+		}
+		return decode(plain, value)
+	}
+}
+
+// Checksummed returns the encoding and decoding funcs of a bucket with checksums,
+// the bucket is marked by AddChecksums so that Store.Verify checks it. The
+// checksummed buckets are listed in a marker bucket because the first bytes of a
+// value can't tell if it has a checksum.
+func Checksummed(encode EncodeFunc, decode DecodeFunc) (EncodeFunc, DecodeFunc) {
+	return ChecksumEncode(encode), ChecksumDecode(decode)
+}
+
+func appendChecksum(data []byte) []byte {
+	var trailer [checksumSize]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.Checksum(data, castagnoli))
+	return append(data[:len(data):len(data)], trailer[:]...)
+}
+
+// verifyChecksum returns the value of data without the trailer.
+func verifyChecksum(data []byte) ([]byte, error) {
+	if len(data) < checksumSize {
+		return nil, &CorruptError{}
+	}
+	value := data[:len(data)-checksumSize]
+	if binary.BigEndian.Uint32(data[len(value):]) != crc32.Checksum(value, castagnoli) {
+		return nil, &CorruptError{}
+	}
+	return value, nil
+}
+
+// corruptError sets the bucket and the key of a CorruptError returned by a decoding func.
+func (b *Bucket) corruptError(key []byte, err error) error {
+	var e *CorruptError
+	if errors.As(err, &e) && e.Bucket == "" && e.Key == "" {
+		return &CorruptError{Bucket: b.Name, Key: string(key)}
+	}
+	return err
+}
+
+// AddChecksums appends the checksum to the values of the bucket and marks the
+// bucket as checksummed, so that Store.Verify checks it. A new bucket is only
+// marked. batchSize values are rewritten in each transaction and the last key is
+// recorded, so a interrupted run is resumed by the next one. The bucket must not
+// be written meanwhile, it is read with ChecksumDecode after. It returns the
+// number of rewritten values.
+func (b *Bucket) AddChecksums(batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	var count int
+	for {
+		var done bool
+		err := b.store.db.Update(func(tx *bolt.Tx) error {
+			bkt := tx.Bucket(b.name)
+			if bkt == nil {
+				return ErrBucketNotFound
+			}
+			marks, err := tx.CreateBucketIfNotExists([]byte(checksumBucket))
+			if err != nil {
+				return err
+			}
+			mark := marks.Get(b.name)
+			if len(mark) > 0 && mark[0] == checksumDone {
+				done = true
+				return nil
+			}
+			var last []byte
+			if len(mark) > 0 {
+				last = mark[1:]
+			}
+
+			var keys, values [][]byte
+			c := bkt.Cursor()
+			k, v := c.First()
+			if len(last) > 0 {
+				k, v = c.Seek(last)
+				if bytes.Equal(k, last) {
+					k, v = c.Next()
+				}
+			}
+			for n := 0; k != nil && n < batchSize; k, v = c.Next() {
+				n++
+				last = append([]byte(nil), k...)
+				if v == nil {
+					continue
+				}
+				keys = append(keys, last)
+				values = append(values, appendChecksum(v))
+			}
+			done = k == nil
+
+			// the values are put after the cursor is done, bolt doesn't allow
+			// to modify a bucket under a cursor.
+			for idx := range keys {
+				if err := bkt.Put(keys[idx], values[idx]); err != nil {
+					return err
+				}
+			}
+			count += len(keys)
+			if done {
+				return marks.Put(b.name, []byte{checksumDone})
+			}
+			return marks.Put(b.name, append([]byte{checksumPartial}, last...))
+		})
+		if err != nil {
+			return count, err
+		}
+		if done {
+			return count, nil
+		}
+	}
+}
+
+// Verify checks the checksums of the values in the buckets marked by AddChecksums,
+// the values of a bucket which is being rewritten are checked up to the last
+// rewritten key. The nested buckets aren't checked. It returns the corrupt values.
+func (s *Store) Verify() ([]*CorruptError, error) {
+	var corrupts []*CorruptError
+	err := s.db.View(func(tx *bolt.Tx) error {
+		marks := tx.Bucket([]byte(checksumBucket))
+		if marks == nil {
+			return nil
+		}
+		return marks.ForEach(func(name, mark []byte) error {
+			b := tx.Bucket(name)
+			if b == nil || len(mark) == 0 {
+				return nil
+			}
+			last := mark[1:]
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil || (len(last) > 0 && bytes.Compare(k, last) > 0) {
+					return nil
+				}
+				if _, err := verifyChecksum(v); err != nil {
+					corrupts = append(corrupts, &CorruptError{Bucket: string(name), Key: string(k)})
+				}
+				return nil
+			})
+		})
+	})
+	return corrupts, err
+}
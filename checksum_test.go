@@ -0,0 +1,134 @@
+package borm_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestChecksum(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		encode, decode := borm.Checksummed(nil, nil)
+		bkt, err := store.CreateBucket("bucktest", encode, decode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		if n, err := bkt.AddChecksums(0); err != nil || n != 0 {
+			t.Fatalf("Got %d, %v wanted 0", n, err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			if err := bkt.Insert(key, &ItemTest{Name: key}); err != nil {
+				t.Fatalf("Error inserting: %s", err)
+			}
+		}
+
+		corrupts, err := store.Verify()
+		if err != nil || len(corrupts) != 0 {
+			t.Fatalf("Got %v, %v wanted no corrupt value", corrupts, err)
+		}
+
+		// flip a bit of the value of b.
+		value, _ := bkt.GetRaw("b")
+		value[len(value)/2] ^= 0x10
+		if err := bkt.PutRaw("b", value); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+
+		err = bkt.Get("b", &ItemTest{})
+		if !borm.IsCorrupt(fmt.Errorf("reading b: %w", err)) {
+			t.Fatalf("Got %v wanted a CorruptError", err)
+		}
+		if e := err.(*borm.CorruptError); e.Bucket != "bucktest" || e.Key != "b" {
+			t.Fatalf("Got %#v", e)
+		}
+
+		err = bkt.ForEach(func(it *borm.Iterator) error {
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if e, ok := err.(*borm.CorruptError); !ok || e.Key != "b" {
+			t.Fatalf("Got %v wanted a CorruptError of b", err)
+		}
+
+		var item ItemTest
+		if err := bkt.Get("a", &item); err != nil || item.Name != "a" {
+			t.Fatalf("Got %v, %v wanted a", item, err)
+		}
+
+		// a value without checksum is corrupt.
+		plain, _ := borm.DefaultEncode(&ItemTest{Name: "d"})
+		if err := bkt.PutRaw("d", plain); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+		corrupts, err = store.Verify()
+		if err != nil {
+			t.Fatalf("Error verifying: %s", err)
+		}
+		if len(corrupts) != 2 || corrupts[0].Key != "b" || corrupts[1].Key != "d" {
+			t.Fatalf("Got %v", corrupts)
+		}
+	})
+}
+
+func TestAddChecksums(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		// msgpack values may start with 0x00, they are checked only if the
+		// bucket is marked.
+		plain, err := store.CreateBucket("bucktest", borm.MsgpackEncode, borm.MsgpackDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		for idx, key := range []string{"a", "b", "c", "d", "e"} {
+			if err := plain.Insert(key, idx); err != nil {
+				t.Fatalf("Error inserting: %s", err)
+			}
+		}
+		if value, _ := plain.GetRaw("a"); len(value) != 1 || value[0] != 0x00 {
+			t.Fatalf("Got %x", value)
+		}
+		corrupts, err := store.Verify()
+		if err != nil || len(corrupts) != 0 {
+			t.Fatalf("Got %v, %v wanted no corrupt value", corrupts, err)
+		}
+
+		encode, decode := borm.Checksummed(borm.MsgpackEncode, borm.MsgpackDecode)
+		bkt, err := store.GetBucket("bucktest", encode, decode)
+		if err != nil {
+			t.Fatalf("Error getting bucket: %s", err)
+		}
+		if n, err := bkt.AddChecksums(2); err != nil || n != 5 {
+			t.Fatalf("Got %d, %v wanted 5", n, err)
+		}
+		// the bucket is marked, the values aren't rewritten again.
+		if n, err := bkt.AddChecksums(2); err != nil || n != 0 {
+			t.Fatalf("Got %d, %v wanted 0", n, err)
+		}
+
+		for idx, key := range []string{"a", "b", "c", "d", "e"} {
+			var value int
+			if err := bkt.Get(key, &value); err != nil || value != idx {
+				t.Fatalf("Got %v, %v wanted %d", value, err, idx)
+			}
+		}
+		corrupts, err = store.Verify()
+		if err != nil || len(corrupts) != 0 {
+			t.Fatalf("Got %v, %v wanted no corrupt value", corrupts, err)
+		}
+
+		value, _ := bkt.GetRaw("a")
+		value[0] ^= 0x01
+		if err := bkt.PutRaw("a", value); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+		corrupts, err = store.Verify()
+		if err != nil || len(corrupts) != 1 || corrupts[0].Bucket != "bucktest" || corrupts[0].Key != "a" {
+			t.Fatalf("Got %v, %v wanted a", corrupts, err)
+		}
+	})
+}
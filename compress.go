@@ -0,0 +1,182 @@
+package borm
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+// compressMarker is the first byte of a value written by CompressEncode, the
+// second byte is the id of the compressor. The encoded values which start with
+// it, as MsgPack, CBOR and raw values may do, are escaped with the id 0. So a
+// value written without CompressEncode is only read as is if it doesn't start
+// with it, Gob and JSON values never do.
+const compressMarker = 0x00
+
+// Compressor is the interface to implement to add a compression algorithm to
+// CompressEncode and CompressDecode. ids 0, 1 and 2 are reserved, and so is
+// 'E' which marks the encrypted values.
+type Compressor interface {
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+type flateCompressor struct {
+	level int
+}
+
+// FlateCompressor returns a Compressor with compress/flate
+func FlateCompressor(level int) Compressor {
+	return flateCompressor{level: level}
+}
+
+func (c flateCompressor) ID() byte { return 1 }
+
+func (c flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	w, err := flate.NewWriter(&buff, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (c flateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// GzipCompressor returns a Compressor with compress/gzip
+func GzipCompressor(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+func (c gzipCompressor) ID() byte { return 2 }
+
+func (c gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buff, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (c gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// CompressEncode wraps encode with compressor c, values smaller than threshold
+// bytes, or which aren't smaller after compressed, are stored raw.
+func CompressEncode(encode EncodeFunc, c Compressor, threshold int) EncodeFunc {
+	if encode == nil {
+		encode = DefaultEncode
+	}
+	idErr := checkCompressor(c)
+	return func(value interface{}) ([]byte, error) {
+		if idErr != nil {
+			return nil, idErr
+		}
+		data, err := encode(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) >= threshold {
+			compressed, err := c.Compress(data)
+			if err != nil {
+				return nil, err
+			}
+			if len(compressed)+2 < len(data) {
+				return append([]byte{compressMarker, c.ID()}, compressed...), nil
+			}
+		}
+		if len(data) > 0 && data[0] == compressMarker {
+			return append([]byte{compressMarker, 0}, data...), nil
+		}
+		return data, nil
+	}
+}
+
+// CompressDecode wraps decode to read the values written by CompressEncode, the
+// flate and gzip compressors are always known, others are passed by compressors.
+func CompressDecode(decode DecodeFunc, compressors ...Compressor) DecodeFunc {
+	if decode == nil {
+		decode = DefaultDecode
+	}
+	var idErr error
+	known := map[byte]Compressor{}
+	for _, c := range append([]Compressor{FlateCompressor(flate.DefaultCompression), GzipCompressor(gzip.DefaultCompression)}, compressors...) {
+		if err := checkCompressor(c); err != nil {
+			idErr = err
+		}
+		known[c.ID()] = c
+	}
+	return func(data []byte, value interface{}) error {
+		if idErr != nil {
+			return idErr
+		}
+		if len(data) < 2 || data[0] != compressMarker {
+			return decode(data, value)
+		}
+		if data[1] == 0 {
+			return decode(data[2:], value)
+		}
+
+		c := known[data[1]]
+		if c == nil {
+			return errors.New("compressor " + strconv.Itoa(int(data[1])) + " is unknown")
+		}
+		raw, err := c.Decompress(data[2:])
+		if err != nil {
+			return err
+		}
+		return decode(raw, value)
+	}
+}
+
+// checkCompressor returns a error if the id of c is the escape id, the id of a
+// standard compressor or used by the other wrappers of the values, the values
+// written by it couldn't be told apart.
+func checkCompressor(c Compressor) error {
+	switch c.(type) {
+	case flateCompressor, gzipCompressor:
+		return nil
+	}
+	switch c.ID() {
+	case 0, 1, 2, encryptMarker[1]:
+		return errors.New("compressor " + strconv.Itoa(int(c.ID())) + " is reserved")
+	}
+	return nil
+}
+
+// Compressed returns the encoding and decoding funcs of a compressed bucket.
+func Compressed(encode EncodeFunc, decode DecodeFunc, c Compressor, threshold int) (EncodeFunc, DecodeFunc) {
+	return CompressEncode(encode, c, threshold), CompressDecode(decode, c)
+}
@@ -0,0 +1,101 @@
+package borm_test
+
+import (
+	"compress/flate"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestCompressedBucket(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		// values are written raw first, then compressed in the same bucket.
+		raw, err := store.CreateBucket("bucktest", borm.JSONEncode, borm.JSONDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		small := &ItemTest{Name: "small", Created: time.Now()}
+		if err := raw.Insert("small", small); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+
+		encode, decode := borm.Compressed(borm.JSONEncode, borm.JSONDecode, borm.FlateCompressor(flate.BestCompression), 256)
+		bkt, err := store.GetBucket("bucktest", encode, decode)
+		if err != nil {
+			t.Fatalf("Error getting bucket: %s", err)
+		}
+		large := &ItemTest{Name: strings.Repeat("large", 100), Created: time.Now()}
+		if err := bkt.Insert("large", large); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+
+		var size int
+		err = bkt.GetRange("large", "large", func(it *borm.Iterator) error {
+			for it.Next() {
+				size = len(it.Value())
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error reading: %s", err)
+		}
+		if size == 0 || size >= len(large.Name) {
+			t.Fatalf("value isn't compressed, got %d bytes", size)
+		}
+
+		for key, expected := range map[string]*ItemTest{"small": small, "large": large} {
+			result := &ItemTest{}
+			if err := bkt.Get(key, result); err != nil {
+				t.Fatalf("Error getting %s: %s", key, err)
+			}
+			if !expected.equal(result) {
+				t.Fatalf("Got %v wanted %v", result, expected)
+			}
+		}
+	})
+}
+
+func TestCompressEscape(t *testing.T) {
+	encode := borm.CompressEncode(func(value interface{}) ([]byte, error) {
+		return value.([]byte), nil
+	}, borm.GzipCompressor(-1), 1024)
+	decode := borm.CompressDecode(func(data []byte, value interface{}) error {
+		*(value.(*[]byte)) = data
+		return nil
+	})
+
+	for _, value := range [][]byte{{0, 1, 2}, {0}, {}, {1, 2}, []byte(strings.Repeat("x", 2048))} {
+		data, err := encode(value)
+		if err != nil {
+			t.Fatalf("Error encoding: %s", err)
+		}
+		var result []byte
+		if err := decode(data, &result); err != nil {
+			t.Fatalf("Error decoding: %s", err)
+		}
+		if string(result) != string(value) {
+			t.Fatalf("Got %v wanted %v", result, value)
+		}
+	}
+}
+
+type reservedCompressor struct {
+	borm.Compressor
+	id byte
+}
+
+func (c reservedCompressor) ID() byte { return c.id }
+
+func TestCompressorReservedID(t *testing.T) {
+	for _, id := range []byte{0, 1, 2, 'E'} {
+		c := reservedCompressor{borm.FlateCompressor(-1), id}
+		if _, err := borm.CompressEncode(nil, c, 0)(&ItemTest{Name: "a"}); err == nil {
+			t.Fatalf("the reserved id %d is used by a compressor", id)
+		}
+		if err := borm.CompressDecode(nil, c)([]byte{0, id}, &ItemTest{}); err == nil {
+			t.Fatalf("the reserved id %d is used by a compressor", id)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package borm
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// csvColumn is a column of ExportCSV, index is the path of the field.
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// csvFields returns the columns of the fields of typ, the fields of nested
+// structs are named by the path joined with ".". A recursive struct is written
+// as JSON.
+func csvFields(prefix string, typ reflect.Type, index []int, parents []reflect.Type) []csvColumn {
+	parents = append(parents, typ)
+	var columns []csvColumn
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		path := append(append([]int(nil), index...), i)
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != timeType && !containsType(parents, ft) {
+			name := prefix + f.Name + "."
+			if f.Anonymous {
+				name = prefix
+			}
+			columns = append(columns, csvFields(name, ft, path, parents)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		columns = append(columns, csvColumn{name: prefix + f.Name, index: path})
+	}
+	return columns
+}
+
+func containsType(types []reflect.Type, typ reflect.Type) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportCSV writes the records between start and end to w as CSV, the records are
+// decoded into the type of example and its fields are flattened into columns.
+// The column "id" is the key and "time" is the time of the id. columns selects
+// and orders the columns, all columns are written if it is empty. The records
+// are written as they are read.
+func (db *TSEngine) ExportCSV(start, end time.Time, w io.Writer, columns []string, example interface{}) error {
+	typ := reflect.TypeOf(example)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return errors.New("example of csv must be a struct")
+	}
+
+	fields := append([]csvColumn{{name: "id"}, {name: "time"}}, csvFields("", typ, nil, nil)...)
+	var selected []csvColumn
+	if len(columns) == 0 {
+		selected = fields
+	}
+	for _, name := range columns {
+		found := false
+		for _, f := range fields {
+			if f.name == name {
+				selected = append(selected, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("column '" + name + "' isn't found in " + typ.String())
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	header := make([]string, len(selected))
+	for idx, c := range selected {
+		header[idx] = c.name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]string, len(selected))
+	err := db.Query(start, end, func(it *Iterator) error {
+		for it.Next() {
+			// a new value for every record, Gob doesn't write the zero fields.
+			value := reflect.New(typ)
+			if err := it.Read(value.Interface()); err != nil {
+				return err
+			}
+
+			key := string(it.Key())
+			for idx, c := range selected {
+				switch {
+				case c.name == "id" && c.index == nil:
+					row[idx] = key
+				case c.name == "time" && c.index == nil:
+					row[idx] = ""
+					if id, err := ParseID(key); err == nil {
+						row[idx] = id.Time().In(db.loc).Format(time.RFC3339Nano)
+					}
+				default:
+					fv, ok := fieldByIndex(value.Elem(), c.index)
+					if !ok {
+						row[idx] = ""
+						continue
+					}
+					s, err := csvValue(fv)
+					if err != nil {
+						return err
+					}
+					row[idx] = s
+				}
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvValue formats a field, the values which aren't scalar are written as JSON.
+func csvValue(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	}
+	bs, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
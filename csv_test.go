@@ -0,0 +1,96 @@
+package borm_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+type csvLocation struct {
+	City string
+	Zip  int
+}
+
+type csvNode struct {
+	Name string
+	Next *csvNode
+}
+
+type csvRecord struct {
+	Host    string
+	Value   float64
+	Seen    time.Time
+	Where   csvLocation
+	Tags    []string
+	Parent  *csvNode
+	private int
+}
+
+func TestExportCSV(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 22, 0, 0, 0, time.UTC)
+		for i := 0; i < 6; i++ {
+			at := start.Add(time.Duration(i) * time.Hour)
+			record := &csvRecord{
+				Host:  "host" + string(rune('a'+i)),
+				Value: float64(i) + 0.5,
+				Seen:  at,
+				Where: csvLocation{City: "x, y", Zip: i},
+				Tags:  []string{"t"},
+			}
+			if i == 0 {
+				record.Parent = &csvNode{Name: "p", Next: &csvNode{Name: "q"}}
+			}
+			err := db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, 0), record)
+			})
+			if err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := db.ExportCSV(start, start.Add(5*time.Hour+time.Minute), &buf, nil, csvRecord{}); err != nil {
+			t.Fatalf("Error exporting: %s", err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("Error reading csv: %s", err)
+		}
+		if len(rows) != 7 {
+			t.Fatalf("Got %d rows wanted 7", len(rows))
+		}
+		header := []string{"id", "time", "Host", "Value", "Seen", "Where.City", "Where.Zip", "Tags", "Parent.Name", "Parent.Next"}
+		if len(rows[0]) != len(header) {
+			t.Fatalf("Got %v wanted %v", rows[0], header)
+		}
+		for idx := range header {
+			if rows[0][idx] != header[idx] {
+				t.Fatalf("Got %v wanted %v", rows[0], header)
+			}
+		}
+		first := rows[1]
+		if first[1] != "2017-10-01T22:00:00Z" || first[2] != "hosta" || first[3] != "0.5" ||
+			first[5] != "x, y" || first[7] != `["t"]` || first[8] != "p" || first[9] != `{"Name":"q","Next":null}` {
+			t.Fatalf("Got %v", first)
+		}
+		if last := rows[6]; last[8] != "" || last[6] != "5" {
+			t.Fatalf("Got %v", last)
+		}
+
+		buf.Reset()
+		if err := db.ExportCSV(start, start.Add(time.Hour), &buf, []string{"time", "Value"}, &csvRecord{}); err != nil {
+			t.Fatalf("Error exporting: %s", err)
+		}
+		if buf.String() != "time,Value\n2017-10-01T22:00:00Z,0.5\n2017-10-01T23:00:00Z,1.5\n" {
+			t.Fatalf("Got %q", buf.String())
+		}
+
+		if err := db.ExportCSV(start, start.Add(time.Hour), &buf, []string{"Missing"}, csvRecord{}); err == nil {
+			t.Fatal("unknown column is exported")
+		}
+	})
+}
@@ -0,0 +1,301 @@
+package borm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrNotEncrypted is returned when a value of a encrypted bucket isn't encrypted
+var ErrNotEncrypted = errors.New("The value isn't encrypted")
+
+// ErrKeyNotFound is returned when the key of a encrypted value isn't in the key provider
+var ErrKeyNotFound = errors.New("The encryption key isn't found")
+
+// encryptMarker is the first two bytes of a encrypted value, they are followed by
+// the length and the id of the key, the nonce and the sealed data. The second
+// byte is reserved in the ids of the compressors.
+var encryptMarker = []byte{compressMarker, 'E'}
+
+// KeyProvider is the interface to implement to supply the keys of a encrypted
+// bucket, new values are encrypted with the current key and old values are
+// decrypted with the key of their id, so keys are rotated by changing the
+// current key and keeping the old ones until Reencrypt is done.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// KeyRing is a KeyProvider in memory
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing returns a KeyRing whose current key is key, key is 16, 24 or 32 bytes
+// to select AES-128, AES-192 or AES-256.
+func NewKeyRing(id string, key []byte) (*KeyRing, error) {
+	r := &KeyRing{keys: map[string][]byte{}}
+	if err := r.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Add adds a old key which is only used to decrypt.
+func (r *KeyRing) Add(id string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	if len(id) == 0 || len(id) > 255 {
+		return errors.New("key id must be 1 to 255 bytes")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate adds key and makes it the current key.
+func (r *KeyRing) Rotate(id string, key []byte) error {
+	if err := r.Add(id, key); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = id
+	return nil
+}
+
+// CurrentKey returns the key which encrypts new values.
+func (r *KeyRing) CurrentKey() (string, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current, r.keys[r.current], nil
+}
+
+// Key returns the key of id.
+func (r *KeyRing) Key(id string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// Encryption encrypts the values of a bucket with AES-GCM, the id of the key is
+// stored with each value.
+//
+// The key of a value isn't known to the codec, so a encrypted value which is
+// copied under another key still decrypts. Set Context to the name of the
+// bucket so that the values can't be copied to other buckets, and authenticate
+// the key in the value itself if the values must not be swapped between keys.
+type Encryption struct {
+	Keys KeyProvider
+	// Context is authenticated with each value but isn't stored, the values
+	// only decrypt with the same Context.
+	Context []byte
+	// Plaintext allows to read the values which aren't encrypted yet, it is used
+	// to migrate a plain bucket with Reencrypt.
+	Plaintext bool
+}
+
+// Encode wraps encode to encrypt the values with the current key.
+func (e *Encryption) Encode(encode EncodeFunc) EncodeFunc {
+	if encode == nil {
+		encode = DefaultEncode
+	}
+	return func(value interface{}) ([]byte, error) {
+		data, err := encode(value)
+		if err != nil {
+			return nil, err
+		}
+		return e.encrypt(data)
+	}
+}
+
+// Decode wraps decode to decrypt the values with the key of their id.
+func (e *Encryption) Decode(decode DecodeFunc) DecodeFunc {
+	if decode == nil {
+		decode = DefaultDecode
+	}
+	return func(data []byte, value interface{}) error {
+		plain, _, err := e.decrypt(data)
+		if err != nil {
+			return err
+		}
+		return decode(plain, value)
+	}
+}
+
+// Encrypted returns the encoding and decoding funcs of a encrypted bucket.
+func Encrypted(encode EncodeFunc, decode DecodeFunc, keys KeyProvider) (EncodeFunc, DecodeFunc) {
+	e := &Encryption{Keys: keys}
+	return e.Encode(encode), e.Decode(decode)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *Encryption) encrypt(data []byte) ([]byte, error) {
+	id, key, err := e.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) == 0 || len(id) > 255 {
+		return nil, errors.New("key id must be 1 to 255 bytes")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(encryptMarker)+1+len(id)+gcm.NonceSize())
+	header = append(header, encryptMarker...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	// the header is authenticated so that the key id can't be changed.
+	out := append(header, nonce...)
+	return gcm.Seal(out, nonce, data, e.additionalData(header)), nil
+}
+
+// additionalData returns the data authenticated with a value, it is the header
+// of the value and the context.
+func (e *Encryption) additionalData(header []byte) []byte {
+	if len(e.Context) == 0 {
+		return header
+	}
+	return append(append([]byte(nil), header...), e.Context...)
+}
+
+// decrypt returns the plain data and the id of its key, the id is empty if the
+// value isn't encrypted.
+func (e *Encryption) decrypt(data []byte) ([]byte, string, error) {
+	if !bytes.HasPrefix(data, encryptMarker) {
+		if e.Plaintext {
+			return data, "", nil
+		}
+		return nil, "", ErrNotEncrypted
+	}
+
+	pos := len(encryptMarker)
+	if len(data) <= pos || len(data) < pos+1+int(data[pos]) {
+		return nil, "", errors.New("encrypted value is truncated")
+	}
+	id := string(data[pos+1 : pos+1+int(data[pos])])
+	header := data[:pos+1+len(id)]
+
+	key, err := e.Keys.Key(id)
+	if err != nil {
+		return nil, "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sealed := data[len(header):]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, "", errors.New("encrypted value is truncated")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], e.additionalData(header))
+	if err != nil {
+		return nil, "", err
+	}
+	return plain, id, nil
+}
+
+// Reencrypt rewrites the values which aren't encrypted with the current key of e,
+// batchSize values are read in each transaction so that the writers aren't blocked
+// for long. It returns the number of rewritten values.
+func (b *Bucket) Reencrypt(e *Encryption, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	current, _, err := e.Keys.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	var last []byte
+	for {
+		var done bool
+		err := b.store.db.Update(func(tx *bolt.Tx) error {
+			bkt := tx.Bucket(b.name)
+			if bkt == nil {
+				return ErrBucketNotFound
+			}
+
+			var keys, values [][]byte
+			c := bkt.Cursor()
+			k, v := c.First()
+			if last != nil {
+				k, v = c.Seek(last)
+				if bytes.Equal(k, last) {
+					k, v = c.Next()
+				}
+			}
+			for n := 0; k != nil && n < batchSize; k, v = c.Next() {
+				n++
+				last = append(last[:0], k...)
+				if v == nil {
+					continue
+				}
+
+				plain, id, err := e.decrypt(v)
+				if err != nil {
+					return err
+				}
+				if id == current {
+					continue
+				}
+				data, err := e.encrypt(plain)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, data)
+			}
+			done = k == nil
+
+			// the values are put after the cursor is done, bolt doesn't allow
+			// to modify a bucket under a cursor.
+			for idx := range keys {
+				if err := bkt.Put(keys[idx], values[idx]); err != nil {
+					return err
+				}
+			}
+			count += len(keys)
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		if done {
+			return count, nil
+		}
+	}
+}
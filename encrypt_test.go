@@ -0,0 +1,154 @@
+package borm_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+)
+
+func TestEncryptedBucket(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		keys, err := borm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+		if err != nil {
+			t.Fatalf("Error creating key ring: %s", err)
+		}
+		encode, decode := borm.Encrypted(nil, nil, keys)
+		bkt, err := store.CreateBucket("bucktest", encode, decode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+
+		expected := &ItemTest{Name: "secret password", Created: time.Now()}
+		if err := bkt.Insert("a", expected); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+
+		err = store.Bolt().View(func(tx *bolt.Tx) error {
+			if bytes.Contains(tx.Bucket([]byte("bucktest")).Get([]byte("a")), []byte(expected.Name)) {
+				t.Fatal("value isn't encrypted")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := &ItemTest{}
+		if err := bkt.Get("a", result); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if !expected.equal(result) {
+			t.Fatalf("Got %v wanted %v", result, expected)
+		}
+
+		other, _ := borm.NewKeyRing("k1", bytes.Repeat([]byte{2}, 32))
+		encode, decode = borm.Encrypted(nil, nil, other)
+		wrong, err := store.GetBucket("bucktest", encode, decode)
+		if err != nil {
+			t.Fatalf("Error getting bucket: %s", err)
+		}
+		if err := wrong.Get("a", &ItemTest{}); err == nil {
+			t.Fatal("value is decrypted with a wrong key")
+		}
+	})
+}
+
+func TestReencrypt(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		// a plain bucket is migrated, then the key is rotated.
+		plain, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		for i := 0; i < 25; i++ {
+			if err := plain.Insert("key"+strconv.Itoa(i), &ItemTest{Key: i, Name: "name" + strconv.Itoa(i)}); err != nil {
+				t.Fatalf("Error inserting: %s", err)
+			}
+		}
+
+		keys, _ := borm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 16))
+		e := &borm.Encryption{Keys: keys, Plaintext: true}
+		bkt, err := store.GetBucket("bucktest", e.Encode(nil), e.Decode(nil))
+		if err != nil {
+			t.Fatalf("Error getting bucket: %s", err)
+		}
+
+		count, err := bkt.Reencrypt(e, 10)
+		if err != nil {
+			t.Fatalf("Error reencrypting: %s", err)
+		}
+		if count != 25 {
+			t.Fatalf("%d values are reencrypted, wanted 25", count)
+		}
+
+		if err := keys.Rotate("k2", bytes.Repeat([]byte{2}, 32)); err != nil {
+			t.Fatalf("Error rotating: %s", err)
+		}
+		if err := bkt.Upsert("key0", &ItemTest{Key: 0, Name: "name0"}); err != nil {
+			t.Fatalf("Error upserting: %s", err)
+		}
+		count, err = bkt.Reencrypt(e, 7)
+		if err != nil {
+			t.Fatalf("Error reencrypting: %s", err)
+		}
+		if count != 24 {
+			t.Fatalf("%d values are reencrypted, wanted 24", count)
+		}
+
+		// the values are readable without the old key and the plain mode.
+		only, _ := borm.NewKeyRing("k2", bytes.Repeat([]byte{2}, 32))
+		encode, decode := borm.Encrypted(nil, nil, only)
+		bkt, err = store.GetBucket("bucktest", encode, decode)
+		if err != nil {
+			t.Fatalf("Error getting bucket: %s", err)
+		}
+		for i := 0; i < 25; i++ {
+			result := &ItemTest{}
+			if err := bkt.Get("key"+strconv.Itoa(i), result); err != nil {
+				t.Fatalf("Error getting: %s", err)
+			}
+			if result.Key != i {
+				t.Fatalf("Got %d wanted %d", result.Key, i)
+			}
+		}
+	})
+}
+
+func TestEncryptionContext(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		keys, _ := borm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+		users := &borm.Encryption{Keys: keys, Context: []byte("users")}
+		bkt, err := store.CreateBucket("users", users.Encode(nil), users.Decode(nil))
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		if err := bkt.Insert("a", &ItemTest{Name: "secret password"}); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+
+		// the value is copied into a bucket with another context.
+		admins := &borm.Encryption{Keys: keys, Context: []byte("admins")}
+		other, err := store.CreateBucket("admins", admins.Encode(nil), admins.Decode(nil))
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		err = store.Bolt().Update(func(tx *bolt.Tx) error {
+			value := tx.Bucket([]byte("users")).Get([]byte("a"))
+			return tx.Bucket([]byte("admins")).Put([]byte("a"), value)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := bkt.Get("a", &ItemTest{}); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if err := other.Get("a", &ItemTest{}); err == nil {
+			t.Fatal("value is decrypted with a wrong context")
+		}
+	})
+}
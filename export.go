@@ -0,0 +1,200 @@
+package borm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// ExportFormat is the format of Bucket.Export
+type ExportFormat string
+
+const (
+	// ExportNDJSON writes a JSON object of the key and the decoded value per line,
+	// the values which can't be decoded are written as ExportRaw.
+	ExportNDJSON ExportFormat = "ndjson"
+	// ExportRaw writes a JSON object of the key and the stored bytes in base64 per line.
+	ExportRaw ExportFormat = "raw"
+)
+
+const importBatchSize = 1000
+
+type exportRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	// Raw is a pointer so that a empty stored value is still written.
+	Raw *[]byte `json:"raw,omitempty"`
+}
+
+// SetValueType sets the type of the values of the bucket, example is a value of
+// the type. Export decodes the values into it and Import encodes the values from
+// it, they use map[string]interface{} if it isn't set.
+func (b *Bucket) SetValueType(example interface{}) {
+	typ := reflect.TypeOf(example)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	b.valueType = typ
+}
+
+func (b *Bucket) newValue() interface{} {
+	if b.valueType == nil {
+		return &map[string]interface{}{}
+	}
+	return reflect.New(b.valueType).Interface()
+}
+
+// Export writes all records of the bucket to w in format, the records are read
+// in one transaction and written as they are read.
+func (b *Bucket) Export(w io.Writer, format ExportFormat) error {
+	if format != ExportNDJSON && format != ExportRaw {
+		return errors.New("export format '" + string(format) + "' is unknown")
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := b.store.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(b.name)
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+
+		return bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+
+			record := exportRecord{Key: string(k)}
+			if format == ExportNDJSON {
+				value := b.newValue()
+				if err := b.decode(v, value); err == nil {
+					data, err := json.Marshal(value)
+					if err != nil {
+						return err
+					}
+					record.Value = data
+				}
+			}
+			if record.Value == nil {
+				record.Raw = &v
+			}
+			return enc.Encode(&record)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import reads the records written by Export from r and puts them into the
+// bucket, the values of ExportNDJSON are encoded by the encoding func of the
+// bucket. The records are put in batches of one transaction each.
+func (b *Bucket) Import(r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var keys, values [][]byte
+	for {
+		var record exportRecord
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var data []byte
+		switch {
+		case record.Value != nil:
+			value, err := b.importValue(record.Value)
+			if err != nil {
+				return errors.New("value of '" + record.Key + "' is invalid: " + err.Error())
+			}
+			if data, err = b.encode(value); err != nil {
+				return err
+			}
+		case record.Raw != nil:
+			data = append([]byte{}, *record.Raw...)
+		default:
+			return errors.New("value of '" + record.Key + "' is missing")
+		}
+
+		keys = append(keys, []byte(record.Key))
+		values = append(values, data)
+		if len(keys) >= importBatchSize {
+			if err := b.putAll(keys, values); err != nil {
+				return err
+			}
+			keys, values = keys[:0], values[:0]
+		}
+	}
+	return b.putAll(keys, values)
+}
+
+// importValue decodes a value of ExportNDJSON, the numbers of a generic value are
+// decoded as int64 or uint64 if they are integers so that they aren't rounded.
+func (b *Bucket) importValue(data json.RawMessage) (interface{}, error) {
+	value := b.newValue()
+	if b.valueType != nil {
+		return value, json.Unmarshal(data, value)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(value); err != nil {
+		return nil, err
+	}
+	return jsonNumbers(value), nil
+}
+
+// jsonNumbers replaces the json.Number in the maps and the slices of v, the
+// encoding funcs don't know it.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *map[string]interface{}:
+		jsonNumbers(*v)
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []interface{}:
+		for idx, e := range v {
+			v[idx] = jsonNumbers(e)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+func (b *Bucket) putAll(keys, values [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return b.store.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(b.name)
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+		for idx := range keys {
+			if err := bkt.Put(keys[idx], values[idx]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
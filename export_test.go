@@ -0,0 +1,141 @@
+package borm_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestExportImport(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		src, err := store.CreateBucket("src", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		src.SetValueType(&ItemTest{})
+		for i := 0; i < 1500; i++ {
+			item := &ItemTest{Key: i, Name: "name" + strconv.Itoa(i), Created: time.Now()}
+			if err := src.Insert(strconv.Itoa(i), item); err != nil {
+				t.Fatalf("Error inserting: %s", err)
+			}
+		}
+
+		for _, format := range []borm.ExportFormat{borm.ExportNDJSON, borm.ExportRaw} {
+			var buf bytes.Buffer
+			if err := src.Export(&buf, format); err != nil {
+				t.Fatalf("Error exporting: %s", err)
+			}
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != 1500 {
+				t.Fatalf("Got %d lines wanted 1500", len(lines))
+			}
+			var first map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+				t.Fatalf("Error parsing: %s", err)
+			}
+			if format == borm.ExportNDJSON {
+				if value, ok := first["value"].(map[string]interface{}); !ok || value["Name"] != "name0" {
+					t.Fatalf("Got %s", lines[0])
+				}
+			} else if _, ok := first["raw"].(string); !ok {
+				t.Fatalf("Got %s", lines[0])
+			}
+
+			dst, err := store.CreateBucket("dst"+string(format), nil, nil)
+			if err != nil {
+				t.Fatalf("Error creating bucket: %s", err)
+			}
+			dst.SetValueType(ItemTest{})
+			if err := dst.Import(&buf); err != nil {
+				t.Fatalf("Error importing: %s", err)
+			}
+
+			for _, key := range []string{"0", "999", "1499"} {
+				expected, result := &ItemTest{}, &ItemTest{}
+				if err := src.Get(key, expected); err != nil {
+					t.Fatalf("Error getting: %s", err)
+				}
+				if err := dst.Get(key, result); err != nil {
+					t.Fatalf("Error getting %s: %s", key, err)
+				}
+				if !expected.equal(result) {
+					t.Fatalf("Got %v wanted %v", result, expected)
+				}
+			}
+		}
+	})
+}
+
+func TestExportGeneric(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", borm.JSONEncode, borm.JSONDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		if err := bkt.Insert("a", map[string]interface{}{"x": 1.5}); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+		if err := bkt.PutRaw("b", []byte("not json")); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+
+		var buf bytes.Buffer
+		if err := bkt.Export(&buf, borm.ExportNDJSON); err != nil {
+			t.Fatalf("Error exporting: %s", err)
+		}
+		expected := `{"key":"a","value":{"x":1.5}}` + "\n" + `{"key":"b","raw":"bm90IGpzb24="}` + "\n"
+		if buf.String() != expected {
+			t.Fatalf("Got %s wanted %s", buf.String(), expected)
+		}
+
+		if err := bkt.Import(strings.NewReader(`{"key":"c","value":{"y":2}}`)); err != nil {
+			t.Fatalf("Error importing: %s", err)
+		}
+		var m map[string]interface{}
+		if err := bkt.Get("c", &m); err != nil || m["y"] != 2.0 {
+			t.Fatalf("Got %v, %v", m, err)
+		}
+		if err := bkt.Import(strings.NewReader(`{"key":"d"}`)); err == nil {
+			t.Fatal("record without value is imported")
+		}
+
+		// the integers aren't rounded to float64.
+		gobs, err := store.CreateBucket("gobs", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		if err := gobs.Import(strings.NewReader(`{"key":"e","value":{"id":9007199254740993,"u":18446744073709551615,"x":1.5}}`)); err != nil {
+			t.Fatalf("Error importing: %s", err)
+		}
+		m = nil
+		if err := gobs.Get("e", &m); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if m["id"] != int64(9007199254740993) || m["u"] != uint64(18446744073709551615) || m["x"] != 1.5 {
+			t.Fatalf("Got %#v", m)
+		}
+
+		// a empty value is exported as raw.
+		if err := gobs.PutRaw("f", []byte{}); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+		var buf2 bytes.Buffer
+		if err := gobs.Export(&buf2, borm.ExportRaw); err != nil {
+			t.Fatalf("Error exporting: %s", err)
+		}
+		if !strings.Contains(buf2.String(), `{"key":"f","raw":""}`) {
+			t.Fatalf("Got %s", buf2.String())
+		}
+		if err := bkt.Import(&buf2); err != nil {
+			t.Fatalf("Error importing: %s", err)
+		}
+		if raw, err := bkt.GetRaw("f"); err != nil || len(raw) != 0 {
+			t.Fatalf("Got %v, %v wanted a empty value", raw, err)
+		}
+	})
+}
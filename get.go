@@ -28,12 +28,21 @@ func (b *Bucket) Get(key string, result interface{}) error {
 			return ErrNotFound
 		}
 
-		return b.decode(value, result)
+		return b.corruptError([]byte(key), b.decode(value, result))
 	})
 }
 
 // GetRange retrieves a set of values from the bolt that matches the key range.
 func (b *Bucket) GetRange(start, end string, cb func(it *Iterator) error) error {
+	return b.getRange(start, end, false, cb)
+}
+
+// GetRangeReverse is same as GetRange, but the iterator goes from end to start.
+func (b *Bucket) GetRangeReverse(start, end string, cb func(it *Iterator) error) error {
+	return b.getRange(start, end, true, cb)
+}
+
+func (b *Bucket) getRange(start, end string, reverse bool, cb func(it *Iterator) error) error {
 	return b.store.db.View(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket(b.name)
 		if bkt == nil {
@@ -46,6 +55,7 @@ func (b *Bucket) GetRange(start, end string, cb func(it *Iterator) error) error
 			startKey: []byte(start),
 			endKey:   []byte(end),
 			isFirst:  true,
+			reverse:  reverse,
 		}
 		if start == "" {
 			it.startKey = nil
@@ -82,12 +92,16 @@ type Iterator struct {
 	startKey []byte
 	endKey   []byte
 	isFirst  bool
+	reverse  bool
 
 	key   []byte
 	value []byte
 }
 
 func (it *Iterator) Next() bool {
+	if it.reverse {
+		return it.prev()
+	}
 	if !it.isFirst {
 		it.key, it.value = it.Cursor.Next()
 	} else {
@@ -108,12 +122,38 @@ func (it *Iterator) Next() bool {
 	return bytes.Compare(it.key, it.endKey) <= 0
 }
 
+func (it *Iterator) prev() bool {
+	if !it.isFirst {
+		it.key, it.value = it.Cursor.Prev()
+	} else {
+		if it.endKey != nil {
+			it.key, it.value = it.Cursor.Seek(it.endKey)
+			if it.key == nil {
+				it.key, it.value = it.Cursor.Last()
+			} else if bytes.Compare(it.key, it.endKey) > 0 {
+				it.key, it.value = it.Cursor.Prev()
+			}
+		} else {
+			it.key, it.value = it.Cursor.Last()
+		}
+		it.isFirst = false
+	}
+	if it.key == nil {
+		return false
+	}
+	if it.startKey == nil {
+		return true
+	}
+
+	return bytes.Compare(it.key, it.startKey) >= 0
+}
+
 func (it *Iterator) Read(value interface{}) error {
-	return it.B.decode(it.value, value)
+	return it.B.corruptError(it.key, it.B.decode(it.value, value))
 }
 
 func (it *Iterator) ReadWith(value interface{}, decoder DecodeFunc) error {
-	return decoder(it.value, value)
+	return it.B.corruptError(it.key, decoder(it.value, value))
 }
 
 func (it *Iterator) Key() []byte {
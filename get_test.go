@@ -40,3 +40,43 @@ func TestGet(t *testing.T) {
 		}
 	})
 }
+
+func TestGetRangeReverse(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket for get test: %s", err)
+		}
+		for _, key := range []string{"a", "c", "e", "g"} {
+			if err := bkt.Insert(key, &ItemTest{Name: key}); err != nil {
+				t.Fatalf("Error creating data for get test: %s", err)
+			}
+		}
+
+		for _, tst := range []struct {
+			start, end string
+			expected   string
+		}{
+			{"", "", "geca"},
+			{"b", "f", "ec"},
+			{"c", "e", "ec"},
+			{"", "d", "ca"},
+			{"d", "", "ge"},
+			{"h", "", ""},
+		} {
+			var keys string
+			err := bkt.GetRangeReverse(tst.start, tst.end, func(it *borm.Iterator) error {
+				for it.Next() {
+					keys += string(it.Key())
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error getting range: %s", err)
+			}
+			if keys != tst.expected {
+				t.Fatalf("GetRangeReverse(%q, %q): got %q wanted %q", tst.start, tst.end, keys, tst.expected)
+			}
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+const gobTypesBucket = "_gob_types"
+
+// GobCodec is a Gob codec which stores compact values, the type descriptors of
+// the registered types are stored once in the store instead of in every value.
+//
+// A value starts with the uvarint index of its type descriptors, it is followed
+// by the Gob message of the value. Types which aren't registered or which have
+// interface fields are encoded with the full descriptors and the index 0.
+type GobCodec struct {
+	store *Store
+
+	mu       sync.RWMutex
+	encoders map[reflect.Type]*gobEncoderType
+	decoders map[uint64]*gobDecoderType
+	names    map[string]uint64
+	next     uint64
+
+	buffers sync.Pool
+}
+
+type gobEncoderType struct {
+	index   uint64
+	prelude []byte
+	pool    sync.Pool
+}
+
+// gobEncoder is a encoder which has sent the type descriptors and a zero value,
+// so it writes only the messages of values.
+type gobEncoder struct {
+	buf bytes.Buffer
+	enc *gob.Encoder
+}
+
+type gobDecoderType struct {
+	prelude []byte
+	pool    sync.Pool
+}
+
+type gobDecoder struct {
+	buf bytes.Buffer
+	dec *gob.Decoder
+}
+
+// NewGobCodec returns a GobCodec whose types are stored in store, the types
+// are loaded from the store and registered again by Register.
+func NewGobCodec(store *Store) (*GobCodec, error) {
+	c := &GobCodec{
+		store:    store,
+		encoders: map[reflect.Type]*gobEncoderType{},
+		decoders: map[uint64]*gobDecoderType{},
+		names:    map[string]uint64{},
+		next:     1,
+	}
+	c.buffers.New = func() interface{} {
+		return new(bytes.Buffer)
+	}
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(gobTypesBucket))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			sep := bytes.IndexByte(v, 0)
+			if len(k) != 8 || sep < 0 {
+				return errors.New("gob type '" + string(k) + "' is invalid")
+			}
+			index := binary.BigEndian.Uint64(k)
+			c.decoders[index] = &gobDecoderType{prelude: append([]byte(nil), v[sep+1:]...)}
+			c.names[string(v[:sep])] = index
+			if index >= c.next {
+				c.next = index + 1
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func gobBaseType(value interface{}) reflect.Type {
+	typ := reflect.TypeOf(value)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+func gobTypeName(typ reflect.Type) string {
+	return typ.PkgPath() + "." + typ.String()
+}
+
+// Register stores the type descriptors of the types of values, the type ids of
+// Gob depend on the order in which types are used, so the types are registered
+// in every process. A type is stored again if its descriptors are changed, the
+// old values are still decoded by the old descriptors.
+func (c *GobCodec) Register(values ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var added []uint64
+	for _, value := range values {
+		typ := gobBaseType(value)
+		if typ == nil {
+			return errors.New("gob type of nil can't be registered")
+		}
+		if _, ok := c.encoders[typ]; ok {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(reflect.New(typ).Interface()); err != nil {
+			return err
+		}
+		prelude := buf.Bytes()
+
+		name := gobTypeName(typ)
+		index, ok := c.names[name]
+		if !ok || !bytes.Equal(c.decoders[index].prelude, prelude) {
+			index = c.next
+			c.next++
+			c.names[name] = index
+			c.decoders[index] = &gobDecoderType{prelude: prelude}
+			added = append(added, index)
+		}
+		c.encoders[typ] = &gobEncoderType{index: index, prelude: c.decoders[index].prelude}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	return c.store.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(gobTypesBucket))
+		if err != nil {
+			return err
+		}
+		for name, index := range c.names {
+			if !containsIndex(added, index) {
+				continue
+			}
+			var k [8]byte
+			binary.BigEndian.PutUint64(k[:], index)
+			v := append(append([]byte(name), 0), c.decoders[index].prelude...)
+			if err := bkt.Put(k[:], v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func containsIndex(indexes []uint64, index uint64) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode is the EncodeFunc of the codec
+func (c *GobCodec) Encode(value interface{}) ([]byte, error) {
+	c.mu.RLock()
+	t := c.encoders[gobBaseType(value)]
+	c.mu.RUnlock()
+
+	if t != nil {
+		e, _ := t.pool.Get().(*gobEncoder)
+		if e == nil {
+			e = &gobEncoder{}
+			e.enc = gob.NewEncoder(&e.buf)
+			if err := e.enc.Encode(reflect.New(gobBaseType(value)).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		e.buf.Reset()
+
+		err := e.enc.Encode(value)
+		// a encoder which sent new type descriptors for a interface field can't be
+		// reused, the descriptors aren't stored.
+		if err == nil && isSingleGobMessage(e.buf.Bytes()) {
+			var prefix [binary.MaxVarintLen64]byte
+			n := binary.PutUvarint(prefix[:], t.index)
+			data := make([]byte, n+e.buf.Len())
+			copy(data, prefix[:n])
+			copy(data[n:], e.buf.Bytes())
+			t.pool.Put(e)
+			return data, nil
+		}
+	}
+
+	buf := c.buffers.Get().(*bytes.Buffer)
+	defer c.buffers.Put(buf)
+	buf.Reset()
+	buf.WriteByte(0)
+	if err := gob.NewEncoder(buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// Decode is the DecodeFunc of the codec
+func (c *GobCodec) Decode(data []byte, value interface{}) error {
+	index, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errors.New("gob value is invalid")
+	}
+	if index == 0 {
+		return gob.NewDecoder(bytes.NewReader(data[n:])).Decode(value)
+	}
+
+	c.mu.RLock()
+	t := c.decoders[index]
+	c.mu.RUnlock()
+	if t == nil {
+		return errors.New("gob type of the value isn't found")
+	}
+
+	d, _ := t.pool.Get().(*gobDecoder)
+	if d == nil {
+		d = &gobDecoder{}
+		d.buf.Write(t.prelude)
+		d.dec = gob.NewDecoder(&d.buf)
+		if err := d.dec.DecodeValue(reflect.Value{}); err != nil {
+			return err
+		}
+	}
+	d.buf.Reset()
+	d.buf.Write(data[n:])
+	if err := d.dec.Decode(value); err != nil {
+		// the state of the decoder is unknown after a error.
+		return err
+	}
+	if d.buf.Len() == 0 {
+		t.pool.Put(d)
+	}
+	return nil
+}
+
+// isSingleGobMessage returns true if data is one Gob message, the type
+// descriptors are sent as messages before the message of the value.
+func isSingleGobMessage(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	length, n := uint64(data[0]), 1
+	if data[0] >= 0x80 {
+		// the length is big endian after the negated byte count.
+		count := int(-int8(data[0]))
+		if count > 8 || len(data) < 1+count {
+			return false
+		}
+		length = 0
+		for _, b := range data[1 : 1+count] {
+			length = length<<8 | uint64(b)
+		}
+		n += count
+	}
+	return uint64(len(data)-n) == length
+}
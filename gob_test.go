@@ -0,0 +1,184 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+type anyItem struct {
+	Name  string
+	Value interface{}
+}
+
+func TestGobCodec(t *testing.T) {
+	filename := tempfile()
+	defer os.Remove(filename)
+
+	store, err := borm.Open(filename, 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", filename, err)
+	}
+	codec, err := borm.NewGobCodec(store)
+	if err != nil {
+		t.Fatalf("Error creating codec: %s", err)
+	}
+	if err := codec.Register(&ItemTest{}, anyItem{}); err != nil {
+		t.Fatalf("Error registering: %s", err)
+	}
+
+	bkt, err := store.CreateBucket("bucktest", codec.Encode, codec.Decode)
+	if err != nil {
+		t.Fatalf("Error creating bucket: %s", err)
+	}
+
+	expected := &ItemTest{Name: "item", Category: "food", Created: time.Now(), Tags: []string{"a", "b"}}
+	if err := bkt.Insert("item", expected); err != nil {
+		t.Fatalf("Error inserting: %s", err)
+	}
+	if err := bkt.Insert("any", &anyItem{Name: "any", Value: "value"}); err != nil {
+		t.Fatalf("Error inserting: %s", err)
+	}
+	if err := bkt.Insert("int", 12); err != nil {
+		t.Fatalf("Error inserting: %s", err)
+	}
+
+	compact, err := codec.Encode(expected)
+	if err != nil {
+		t.Fatalf("Error encoding: %s", err)
+	}
+	full, err := borm.DefaultEncode(expected)
+	if err != nil {
+		t.Fatalf("Error encoding: %s", err)
+	}
+	if len(compact) >= len(full)/2 {
+		t.Fatalf("value isn't compact, got %d bytes, default is %d bytes", len(compact), len(full))
+	}
+	store.Close()
+
+	// the types are loaded from the store after reopened.
+	store, err = borm.Open(filename, 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", filename, err)
+	}
+	defer store.Close()
+	codec, err = borm.NewGobCodec(store)
+	if err != nil {
+		t.Fatalf("Error creating codec: %s", err)
+	}
+	bkt, err = store.GetBucket("bucktest", codec.Encode, codec.Decode)
+	if err != nil {
+		t.Fatalf("Error getting bucket: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		result := &ItemTest{}
+		if err := bkt.Get("item", result); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if !expected.equal(result) {
+			t.Fatalf("Got %v wanted %v", result, expected)
+		}
+	}
+
+	var any anyItem
+	if err := bkt.Get("any", &any); err != nil {
+		t.Fatalf("Error getting: %s", err)
+	}
+	if any.Name != "any" || any.Value != "value" {
+		t.Fatalf("Got %v", any)
+	}
+
+	var i int
+	if err := bkt.Get("int", &i); err != nil {
+		t.Fatalf("Error getting: %s", err)
+	}
+	if i != 12 {
+		t.Fatalf("Got %d wanted 12", i)
+	}
+}
+
+func benchmarkItem() *ItemTest {
+	return &ItemTest{
+		Key:      1,
+		Name:     "benchmark",
+		Category: "vehicle",
+		Created:  time.Now(),
+		Tags:     []string{"fast", "red"},
+		Color:    "red",
+		Fruit:    "apple",
+	}
+}
+
+func benchmarkCodec(b *testing.B) *borm.GobCodec {
+	filename := tempfile()
+	store, err := borm.Open(filename, 0666, nil)
+	if err != nil {
+		b.Fatalf("Error opening %s: %s", filename, err)
+	}
+	codec, err := borm.NewGobCodec(store)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := codec.Register(&ItemTest{}); err != nil {
+		b.Fatal(err)
+	}
+	store.Close()
+	os.Remove(filename)
+	return codec
+}
+
+func BenchmarkDefaultEncode(b *testing.B) {
+	item := benchmarkItem()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := borm.DefaultEncode(item); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGobCodecEncode(b *testing.B) {
+	codec := benchmarkCodec(b)
+	item := benchmarkItem()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Encode(item); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDefaultDecode(b *testing.B) {
+	data, err := borm.DefaultEncode(benchmarkItem())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result ItemTest
+		if err := borm.DefaultDecode(data, &result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGobCodecDecode(b *testing.B) {
+	codec := benchmarkCodec(b)
+	data, err := codec.Encode(benchmarkItem())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result ItemTest
+		if err := codec.Decode(data, &result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
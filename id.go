@@ -3,6 +3,8 @@ package borm
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"strconv"
 	"sync/atomic"
 	"time"
 )
@@ -13,6 +15,84 @@ var (
 	idCounter uint32
 )
 
+// ErrInvalidID is returned when a string isn't a valid ObjectId
+var ErrInvalidID = errors.New("Invalid ObjectId")
+
+// ID is a parsed ObjectId, 4 bytes of unix time followed by 4 bytes of counter.
+type ID [8]byte
+
+// NewID create a ID from time and counter.
+func NewID(t time.Time, count uint32) ID {
+	var id ID
+	// Timestamp, 4 bytes, big endian
+	binary.BigEndian.PutUint32(id[:], uint32(t.UTC().Unix()))
+	// idCounter, 4 bytes, big endian
+	binary.BigEndian.PutUint32(id[4:], count)
+	return id
+}
+
+// ParseID parses a hex encoded ObjectId string, it accepts upper case, String
+// of the result is the lower case key stored in the shards.
+func ParseID(s string) (ID, error) {
+	var id ID
+	if len(s) != 2*len(id) {
+		return id, ErrInvalidID
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return id, ErrInvalidID
+	}
+	return id, nil
+}
+
+// Time returns the timestamp part of the id.
+func (id ID) Time() time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint32(id[:4])), 0)
+}
+
+// Counter returns the counter part of the id.
+func (id ID) Counter() uint32 {
+	return binary.BigEndian.Uint32(id[4:])
+}
+
+// String returns the hex encoding of the id.
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Bytes returns the raw bytes of the id.
+func (id ID) Bytes() []byte {
+	return id[:]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (id *ID) UnmarshalText(data []byte) error {
+	parsed, err := ParseID(string(data))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (id ID) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(id.String())), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		return ErrInvalidID
+	}
+	return id.UnmarshalText([]byte(s))
+}
+
 // GenerateID returns a new unique ObjectId.
 func GenerateID() string {
 	return CreateID(time.Now(), atomic.AddUint32(&idCounter, 1))
@@ -20,22 +100,14 @@ func GenerateID() string {
 
 // CreateID create a unique ObjectId.
 func CreateID(t time.Time, count uint32) string {
-	var b [8]byte
-	// Timestamp, 4 bytes, big endian
-	binary.BigEndian.PutUint32(b[:], uint32(t.UTC().Unix()))
-	// idCounter, 4 bytes, big endian
-	binary.BigEndian.PutUint32(b[4:], count)
-	return hex.EncodeToString(b[:])
+	return NewID(t, count).String()
 }
 
-// TimeFromID read time from id string.
+// TimeFromID read time from id string, it returns the zero time if id is invalid.
 func TimeFromID(id string) time.Time {
-	bs, err := hex.DecodeString(id)
+	parsed, err := ParseID(id)
 	if err != nil {
 		return time.Time{}
 	}
-
-	// Timestamp, 4 bytes, big endian
-	unix := binary.BigEndian.Uint32(bs[:])
-	return time.Unix(int64(unix), 0)
+	return parsed.Time()
 }
@@ -0,0 +1,61 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestParseID(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	s := borm.CreateID(now, 42)
+
+	id, err := borm.ParseID(s)
+	if err != nil {
+		t.Fatalf("Error parsing id %s: %s", s, err)
+	}
+	if !id.Time().Equal(now) {
+		t.Fatalf("Got time %v wanted %v", id.Time(), now)
+	}
+	if id.Counter() != 42 {
+		t.Fatalf("Got counter %d wanted 42", id.Counter())
+	}
+	if id.String() != s {
+		t.Fatalf("Got %s wanted %s", id.String(), s)
+	}
+
+	for _, bad := range []string{"", "ab", "zzzzzzzzzzzzzzzz", s + "00"} {
+		if _, err := borm.ParseID(bad); err != borm.ErrInvalidID {
+			t.Fatalf("ParseID(%q) didn't fail! Expected %s got %v", bad, borm.ErrInvalidID, err)
+		}
+		if !borm.TimeFromID(bad).IsZero() {
+			t.Fatalf("TimeFromID(%q) should be zero", bad)
+		}
+	}
+}
+
+func TestIDJSON(t *testing.T) {
+	id := borm.NewID(time.Now(), 7)
+
+	bs, err := json.Marshal(id)
+	if err != nil {
+		t.Fatalf("Error marshaling id: %s", err)
+	}
+	if string(bs) != `"`+id.String()+`"` {
+		t.Fatalf("Got %s wanted %q", bs, id.String())
+	}
+
+	var result borm.ID
+	if err := json.Unmarshal(bs, &result); err != nil {
+		t.Fatalf("Error unmarshaling id: %s", err)
+	}
+	if result != id {
+		t.Fatalf("Got %s wanted %s", result, id)
+	}
+
+	if err := json.Unmarshal([]byte(`"abc"`), &result); err == nil {
+		t.Fatalf("Unmarshal didn't fail for invalid id")
+	}
+}
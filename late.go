@@ -0,0 +1,85 @@
+package borm
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLateWrite is returned by TSEngine.Write when the write is older than the lateness window
+var ErrLateWrite = errors.New("The write is older than the lateness window of the engine")
+
+// LateWritePolicy describes how TSEngine handles the writes which are older than
+// the newest write.
+type LateWritePolicy struct {
+	// Window keeps a shard open for writing until the newest write is Window
+	// past its end, so that interleaved writes for today and yesterday don't
+	// reopen shards. Only the newest shard is kept open if it is zero.
+	Window time.Duration
+	// Reject returns ErrLateWrite for the writes older than Window, otherwise
+	// their shard is opened for the write only.
+	Reject bool
+}
+
+// LateWriteStats are the counters of the writes which are older than the newest write.
+type LateWriteStats struct {
+	// OutOfOrder is the number of writes older than the newest write.
+	OutOfOrder int64
+	// Late is the number of writes older than the lateness window.
+	Late       int64
+	Redirected int64
+	Rejected   int64
+	// MaxLateness is the max duration between a write and the newest write.
+	MaxLateness time.Duration
+}
+
+// SetLateWritePolicy changes the policy of late writes, the shards which are out
+// of the new window are closed.
+func (db *TSEngine) SetLateWritePolicy(policy LateWritePolicy) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.late = policy
+	return db.evict()
+}
+
+// LateWriteStats returns the statistics of late writes since the engine is opened.
+func (db *TSEngine) LateWriteStats() LateWriteStats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.lateStats
+}
+
+// isLate returns true if a shard ending at end is out of the lateness window.
+func (db *TSEngine) isLate(end time.Time) bool {
+	return !end.After(db.watermark.Add(-db.late.Window))
+}
+
+// trackLateness updates the statistics with a write at t, it returns true if
+// the write is out of the lateness window.
+func (db *TSEngine) trackLateness(t time.Time) bool {
+	if !t.Before(db.watermark) {
+		return false
+	}
+
+	db.lateStats.OutOfOrder++
+	if lateness := db.watermark.Sub(t); lateness > db.lateStats.MaxLateness {
+		db.lateStats.MaxLateness = lateness
+	}
+
+	if !db.isLate(startOfDay(t, db.loc).AddDate(0, 0, 1)) {
+		return false
+	}
+	db.lateStats.Late++
+	return true
+}
+
+// advance moves the newest write time to t after a write at t succeeded, the
+// shards which are out of the lateness window then are closed.
+func (db *TSEngine) advance(t time.Time) error {
+	if !t.After(db.watermark) {
+		return nil
+	}
+	db.watermark = t
+	return db.evict()
+}
@@ -0,0 +1,363 @@
+package borm
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const manifestName = ".manifest.json"
+
+// ShardState is the state of a shard in the manifest
+type ShardState string
+
+const (
+	ShardActive   ShardState = "active"
+	ShardSealed   ShardState = "sealed"
+	ShardArchived ShardState = "archived"
+)
+
+// ShardInfo is the metadata of a shard recorded in the manifest
+type ShardInfo struct {
+	Name    string     `json:"name"`
+	Start   time.Time  `json:"start"`
+	End     time.Time  `json:"end"`
+	Series  []string   `json:"series,omitempty"`
+	Records int64      `json:"records"`
+	Size    int64      `json:"size"`
+	MinID   string     `json:"min_id,omitempty"`
+	MaxID   string     `json:"max_id,omitempty"`
+	State   ShardState `json:"state"`
+	// PageSize, DataSize and Buckets are the statistics of the compacted shard,
+	// they are recorded when the shard is archived.
+	PageSize int           `json:"page_size,omitempty"`
+	DataSize int64         `json:"data_size,omitempty"`
+	Buckets  []BucketStats `json:"buckets,omitempty"`
+}
+
+// manifest is the catalog of the shards of a TSEngine, it is stored in the base path
+// of the engine and replaced atomically on every change.
+type manifest struct {
+	path   string
+	loc    *time.Location
+	shards map[string]*ShardInfo
+}
+
+func loadManifest(basePath string, loc *time.Location) (*manifest, error) {
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, err
+	}
+
+	m := &manifest{
+		path:   filepath.Join(basePath, manifestName),
+		loc:    loc,
+		shards: map[string]*ShardInfo{},
+	}
+
+	bs, err := ioutil.ReadFile(m.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := m.rebuild(basePath); err != nil {
+			return nil, err
+		}
+		return m, m.save()
+	}
+
+	var infos []*ShardInfo
+	if err := json.Unmarshal(bs, &infos); err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		m.shards[info.Name] = info
+	}
+	return m, nil
+}
+
+// rebuild scans the shard files in basePath and the archive directory, the time
+// range of a shard is parsed from its name or derived from its ids.
+func (m *manifest) rebuild(basePath string) error {
+	files, err := ioutil.ReadDir(basePath)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() ||
+			strings.HasPrefix(fi.Name(), ".") ||
+			strings.HasSuffix(fi.Name(), ".lock") {
+			continue
+		}
+
+		info, err := readShardInfo(filepath.Join(basePath, fi.Name()), m.loc)
+		if err != nil {
+			return err
+		}
+		if info != nil {
+			m.shards[info.Name] = info
+		}
+	}
+
+	files, err = ioutil.ReadDir(filepath.Join(basePath, "archive"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), archiveExt) {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), archiveExt)
+		shard, err := openShard(name, m.loc)
+		if err != nil {
+			continue
+		}
+		m.shards[name] = &ShardInfo{
+			Name:  name,
+			Start: shard.startTime,
+			End:   shard.endTime,
+			Size:  fi.Size(),
+			State: ShardArchived,
+		}
+	}
+	return nil
+}
+
+func readShardInfo(file string, loc *time.Location) (*ShardInfo, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(file, 0444, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	info := &ShardInfo{
+		Name:  filepath.Base(file),
+		Size:  fi.Size(),
+		State: ShardSealed,
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			info.updateStats(string(name), b)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if shard, err := openShard(file, loc); err == nil {
+		info.Start = shard.startTime
+		info.End = shard.endTime
+	} else if minID, err := ParseID(info.MinID); err == nil {
+		maxID, _ := ParseID(info.MaxID)
+		info.Start = startOfDay(minID.Time(), loc)
+		info.End = startOfDay(maxID.Time(), loc).AddDate(0, 0, 1)
+	} else {
+		// it is a empty shard with a custom name.
+		return nil, nil
+	}
+	return info, nil
+}
+
+func (info *ShardInfo) updateStats(series string, b *bolt.Bucket) {
+	info.Series = append(info.Series, series)
+	info.Records += int64(b.Stats().KeyN)
+
+	c := b.Cursor()
+	if k, _ := c.First(); k != nil && (info.MinID == "" || string(k) < info.MinID) {
+		info.MinID = string(k)
+	}
+	if k, _ := c.Last(); k != nil && string(k) > info.MaxID {
+		info.MaxID = string(k)
+	}
+}
+
+func (m *manifest) list() []*ShardInfo {
+	infos := make([]*ShardInfo, 0, len(m.shards))
+	for _, info := range m.shards {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Start.Equal(infos[j].Start) {
+			return infos[i].Name < infos[j].Name
+		}
+		return infos[i].Start.Before(infos[j].Start)
+	})
+	return infos
+}
+
+func (m *manifest) save() error {
+	bs, err := json.MarshalIndent(m.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, func(w io.Writer) error {
+		_, err := w.Write(bs)
+		return err
+	})
+}
+
+// activate records a new active shard which covers the day of t.
+func (m *manifest) activate(fileName string, t time.Time) error {
+	name := filepath.Base(fileName)
+	info := m.shards[name]
+	if info == nil {
+		start := startOfDay(t, m.loc)
+		info = &ShardInfo{
+			Name:  name,
+			Start: start,
+			End:   start.AddDate(0, 0, 1),
+		}
+		m.shards[name] = info
+	} else if info.State == ShardActive {
+		return nil
+	}
+	// the older shards may still be open within the lateness window, they are
+	// sealed when they are closed.
+	info.State = ShardActive
+	info.PageSize, info.DataSize, info.Buckets = 0, 0, nil
+	return m.save()
+}
+
+// seal records the statistics of a shard when it is closed.
+func (m *manifest) seal(fileName string, store *Store) error {
+	info := m.shards[filepath.Base(fileName)]
+	if info == nil {
+		return nil
+	}
+
+	info.Series = nil
+	info.Records = 0
+	info.MinID = ""
+	info.MaxID = ""
+	err := store.Bolt().View(func(tx *bolt.Tx) error {
+		info.Size = tx.Size()
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			info.updateStats(string(name), b)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	info.State = ShardSealed
+	return m.save()
+}
+
+// archive records the statistics of a shard when it is archived, stats are the
+// statistics of the compacted shard.
+func (m *manifest) archive(fileName string, size int64, stats *ShardStats) error {
+	info := m.shards[filepath.Base(fileName)]
+	if info == nil {
+		return nil
+	}
+	info.State = ShardArchived
+	info.Size = size
+	info.PageSize = stats.PageSize
+	info.DataSize = stats.DataSize
+	info.Buckets = stats.Buckets
+	return m.save()
+}
+
+func (m *manifest) remove(fileName string) error {
+	delete(m.shards, filepath.Base(fileName))
+	return m.save()
+}
+
+// overlapping returns the shards which overlap the range between start and end,
+// they are ordered by start time.
+func (m *manifest) overlapping(start, end time.Time) []*ShardInfo {
+	var infos []*ShardInfo
+	for _, info := range m.list() {
+		if info.Start.Before(end) && info.End.After(start) {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// covering returns the shard which covers t, it may be archived.
+func (m *manifest) covering(t time.Time) *ShardInfo {
+	for _, info := range m.shards {
+		if !info.Start.After(t) && info.End.After(t) {
+			return info
+		}
+	}
+	return nil
+}
+
+// sealed returns true if the shard is sealed or archived, it is opened read only.
+func (m *manifest) sealed(fileName string) bool {
+	info := m.shards[filepath.Base(fileName)]
+	return info != nil && info.State != ShardActive
+}
+
+// archived returns true if the shard is in the archive.
+func (m *manifest) archived(fileName string) bool {
+	info := m.shards[filepath.Base(fileName)]
+	return info != nil && info.State == ShardArchived
+}
+
+// has returns true if the shard exists, in the base path or in the archive.
+func (m *manifest) has(fileName string) bool {
+	_, ok := m.shards[filepath.Base(fileName)]
+	return ok
+}
+
+// Refresh reloads the manifest from disk, a reader process calls it to see the
+// shards written by the writer process.
+func (db *TSEngine) Refresh() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, err := loadManifest(db.basePath, db.loc)
+	if err != nil {
+		return err
+	}
+	db.manifest = m
+	return nil
+}
+
+// Shards returns the shards recorded in the manifest ordered by start time.
+func (db *TSEngine) Shards() []ShardInfo {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	infos := db.manifest.list()
+	results := make([]ShardInfo, 0, len(infos))
+	for _, info := range infos {
+		results = append(results, *info)
+	}
+	return results
+}
+
+// shards returns the shards in the base path, they are ordered by decreasing time.
+func (db *TSEngine) shards() Shards {
+	var shards Shards
+	for _, info := range db.manifest.list() {
+		if info.State == ShardArchived {
+			continue
+		}
+		shards = append(shards, &Shard{
+			path:      filepath.Join(db.basePath, info.Name),
+			startTime: info.Start,
+			endTime:   info.End,
+		})
+	}
+	sort.Sort(shards)
+	return shards
+}
@@ -0,0 +1,64 @@
+package borm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	nameWith := func(t time.Time) string {
+		return "shard-" + t.Format("20060102") + ".db"
+	}
+
+	db, err := borm.OpenTSEngine(dir, nameWith)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeDays(t, db, start, 2)
+
+	infos := db.Shards()
+	if len(infos) != 2 {
+		t.Fatalf("Got %d shards wanted 2", len(infos))
+	}
+	if infos[0].State != borm.ShardSealed || infos[0].Records != 1 || infos[0].MinID != borm.CreateID(start, 0) {
+		t.Fatalf("Got %#v", infos[0])
+	}
+	if infos[1].State != borm.ShardActive {
+		t.Fatalf("Got state %s wanted %s", infos[1].State, borm.ShardActive)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing engine: %s", err)
+	}
+
+	// the manifest is rebuilt from the shard files.
+	if err := os.Remove(filepath.Join(dir, ".manifest.json")); err != nil {
+		t.Fatalf("Error removing manifest: %s", err)
+	}
+	db, err = borm.OpenTSEngine(dir, nameWith)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	infos = db.Shards()
+	if len(infos) != 2 {
+		t.Fatalf("Got %d shards wanted 2", len(infos))
+	}
+	for idx, info := range infos {
+		if !info.Start.Equal(time.Date(2017, 10, 1+idx, 0, 0, 0, 0, time.UTC)) || info.Records != 1 {
+			t.Fatalf("Got %#v", info)
+		}
+	}
+}
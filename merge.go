@@ -0,0 +1,355 @@
+package borm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// MergeShards merges the sealed shards which are inside the range between start
+// and end into one shard named name, for example to merge seven daily shards
+// into a weekly shard. name defaults to the names of the first and the last day.
+// The merged shard is written to a temp file and renamed, then the manifest is
+// updated and the merged shards are removed.
+func (db *TSEngine) MergeShards(start, end time.Time, name string) (*ShardInfo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushBuffer(); err != nil {
+		return nil, err
+	}
+
+	// the merged shards are removed, so the callbacks reading them are waited for.
+	for db.inUse(db.manifest.overlapping(start, end)) {
+		db.idle.Wait()
+	}
+
+	var sources []*ShardInfo
+	for _, info := range db.manifest.overlapping(start, end) {
+		if info.Start.Before(start) || info.End.After(end) || info.State == ShardArchived {
+			continue
+		}
+		if info.State != ShardSealed || db.writers[filepath.Join(db.basePath, info.Name)] != nil {
+			return nil, errors.New("shard '" + info.Name + "' is open for writing")
+		}
+		sources = append(sources, info)
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("there is no shard to merge")
+	}
+
+	first, last := sources[0], sources[len(sources)-1]
+	if name == "" {
+		name = strings.TrimSuffix(tsName(first.Start), ".ts") + "-" + tsName(last.End.AddDate(0, 0, -1))
+	}
+	if db.manifest.has(name) {
+		return nil, errors.New("shard '" + name + "' already exists")
+	}
+
+	target := filepath.Join(db.basePath, name)
+	err := rewriteFile(target, func(dst *bolt.DB) error {
+		for _, info := range sources {
+			if err := copyFile(dst, filepath.Join(db.basePath, info.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := db.replaceShards(sources, []*shardRange{{name: name, start: first.Start, end: last.End}})
+	if err != nil {
+		return nil, err
+	}
+	return merged[0], nil
+}
+
+// SplitShard splits a sealed shard by time at boundaries, records are moved
+// into the new shards by the time of their id. The shard is split by days if
+// boundaries is empty. The new shards are named by the days if they cover a day
+// exactly, otherwise by the name of the shard and their start time.
+func (db *TSEngine) SplitShard(name string, boundaries ...time.Time) ([]*ShardInfo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushBuffer(); err != nil {
+		return nil, err
+	}
+
+	if !db.waitIdle(filepath.Join(db.basePath, name)) {
+		return nil, errors.New("shard '" + name + "' isn't found")
+	}
+	source := db.manifest.shards[name]
+	if source == nil {
+		return nil, errors.New("shard '" + name + "' isn't found")
+	}
+	if source.State != ShardSealed || db.writers[filepath.Join(db.basePath, name)] != nil {
+		return nil, errors.New("shard '" + name + "' isn't sealed")
+	}
+
+	if len(boundaries) == 0 {
+		for day := startOfDay(source.Start, db.loc).AddDate(0, 0, 1); day.Before(source.End); day = day.AddDate(0, 0, 1) {
+			boundaries = append(boundaries, day)
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].Before(boundaries[j])
+	})
+
+	ranges := []*shardRange{{start: source.Start}}
+	for _, boundary := range boundaries {
+		current := ranges[len(ranges)-1]
+		if !boundary.After(current.start) || !boundary.Before(source.End) {
+			continue
+		}
+		current.end = boundary
+		ranges = append(ranges, &shardRange{start: boundary})
+	}
+	ranges[len(ranges)-1].end = source.End
+	if len(ranges) < 2 {
+		return nil, errors.New("shard '" + name + "' can't be split by the boundaries")
+	}
+
+	ext := filepath.Ext(name)
+	for _, r := range ranges {
+		if r.start.Equal(startOfDay(r.start, db.loc)) && r.end.Equal(r.start.AddDate(0, 0, 1)) {
+			r.name = filepath.Base(db.nameWith(r.start))
+		} else {
+			r.name = strings.TrimSuffix(name, ext) + "_" + r.start.In(db.loc).Format(splitLayout) + ext
+		}
+		if r.name != name && db.manifest.has(r.name) {
+			return nil, errors.New("shard '" + r.name + "' already exists")
+		}
+	}
+
+	if err := db.splitFile(filepath.Join(db.basePath, name), ranges); err != nil {
+		return nil, err
+	}
+	return db.replaceShards([]*ShardInfo{source}, ranges)
+}
+
+type shardRange struct {
+	name       string
+	start, end time.Time
+}
+
+func (db *TSEngine) splitFile(file string, ranges []*shardRange) error {
+	src, err := bolt.Open(file, 0444, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmps := make([]*bolt.DB, len(ranges))
+	defer func() {
+		for idx, tmp := range tmps {
+			if tmp != nil {
+				tmp.Close()
+			}
+			os.Remove(splitTemp(db.basePath, ranges[idx].name))
+		}
+	}()
+	for idx, r := range ranges {
+		os.Remove(splitTemp(db.basePath, r.name))
+		tmps[idx], err = bolt.Open(splitTemp(db.basePath, r.name), 0666, &bolt.Options{Timeout: 10 * time.Second})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the records are put in order, so each temp file is written in one transaction.
+	txs := make([]*bolt.Tx, len(tmps))
+	for idx, tmp := range tmps {
+		txs[idx], err = tmp.Begin(true)
+		if err != nil {
+			for _, tx := range txs[:idx] {
+				tx.Rollback()
+			}
+			return err
+		}
+	}
+	err = src.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(bucketName []byte, b *bolt.Bucket) error {
+			buckets := make([]*bolt.Bucket, len(txs))
+			for idx, tx := range txs {
+				bkt, err := tx.CreateBucketIfNotExists(bucketName)
+				if err != nil {
+					return err
+				}
+				bkt.FillPercent = 1.0
+				buckets[idx] = bkt
+			}
+
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					child, err := buckets[0].CreateBucketIfNotExists(k)
+					if err != nil {
+						return err
+					}
+					return copyBucket(child, b.Bucket(k))
+				}
+
+				// the records whose id isn't valid or out of the shard are kept
+				// in the first or the last shard.
+				idx := 0
+				if id, err := ParseID(string(k)); err == nil {
+					t := id.Time()
+					for idx < len(ranges)-1 && !t.Before(ranges[idx].end) {
+						idx++
+					}
+				}
+				return buckets[idx].Put(k, v)
+			})
+		})
+	})
+	for _, tx := range txs {
+		if err != nil {
+			tx.Rollback()
+		} else if e := tx.Commit(); e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for idx := range tmps {
+		if err := tmps[idx].Close(); err != nil {
+			return err
+		}
+		tmps[idx] = nil
+	}
+	for _, r := range ranges {
+		if err := os.Rename(splitTemp(db.basePath, r.name), filepath.Join(db.basePath, r.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitTemp(basePath, name string) string {
+	return filepath.Join(basePath, "."+name+".tmp")
+}
+
+// replaceShards updates the manifest with the new shards in one save, then removes
+// the old shards and builds the bloom filters of the new shards.
+func (db *TSEngine) replaceShards(old []*ShardInfo, ranges []*shardRange) ([]*ShardInfo, error) {
+	var added []*ShardInfo
+	for _, r := range ranges {
+		info, err := readShardInfo(filepath.Join(db.basePath, r.name), db.loc)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			info = &ShardInfo{Name: r.name, State: ShardSealed}
+		}
+		info.Start = r.start
+		info.End = r.end
+		added = append(added, info)
+	}
+
+	for _, info := range old {
+		if !containsShard(added, info.Name) {
+			delete(db.manifest.shards, info.Name)
+		}
+	}
+	for _, info := range added {
+		db.manifest.shards[info.Name] = info
+	}
+	if err := db.manifest.save(); err != nil {
+		return nil, err
+	}
+
+	for _, info := range old {
+		file := filepath.Join(db.basePath, info.Name)
+		db.removeBloom(file)
+		if !containsShard(added, info.Name) {
+			if err := os.Remove(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, info := range added {
+		store, bkt, err := db.openReadOnly(filepath.Join(db.basePath, info.Name))
+		if err == ErrBucketNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = db.buildBloom(filepath.Join(db.basePath, info.Name), bkt)
+		store.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return added, nil
+}
+
+// inUse returns true if a callback uses one of the shards of infos.
+func (db *TSEngine) inUse(infos []*ShardInfo) bool {
+	for _, info := range infos {
+		if db.handles[filepath.Join(db.basePath, info.Name)] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsShard(infos []*ShardInfo, name string) bool {
+	for _, info := range infos {
+		if info.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteFile writes a new bolt file by write into a temp file and renames it to target.
+func rewriteFile(target string, write func(dst *bolt.DB) error) error {
+	tmp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	os.Remove(tmp)
+
+	dst, err := bolt.Open(tmp, 0666, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return err
+	}
+	if err := write(dst); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// copyFile copies all buckets of the bolt file src into dst.
+func copyFile(dst *bolt.DB, src string) error {
+	srcDB, err := bolt.Open(src, 0444, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	return srcDB.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				bkt, err := dstTx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(bkt, b)
+			})
+		})
+	})
+}
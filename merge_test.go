@@ -0,0 +1,133 @@
+package borm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func countRecords(t *testing.T, db *borm.TSEngine, start, end time.Time) int {
+	var count int
+	err := db.Query(start, end, func(it *borm.Iterator) error {
+		for it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	return count
+}
+
+func TestMergeAndSplitShards(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+		writeDays(t, db, start.Add(12*time.Hour), 8)
+		week := start.AddDate(0, 0, 7)
+
+		merged, err := db.MergeShards(start, week, "")
+		if err != nil {
+			t.Fatalf("Error merging shards: %s", err)
+		}
+		if merged.Name != "2017_274-2017_280.ts" || merged.Records != 7 ||
+			!merged.Start.Equal(start) || !merged.End.Equal(week) {
+			t.Fatalf("Got %#v", merged)
+		}
+		if len(db.Shards()) != 2 {
+			t.Fatalf("Got %d shards wanted 2", len(db.Shards()))
+		}
+		if count := countRecords(t, db, start, week.AddDate(0, 0, 2)); count != 8 {
+			t.Fatalf("Got %d records wanted 8", count)
+		}
+		var s sample
+		if err := db.Get(borm.CreateID(start.AddDate(0, 0, 3).Add(12*time.Hour), 3), &s); err != nil || s.Value != 3 {
+			t.Fatalf("Got %v, %v wanted 3", s.Value, err)
+		}
+
+		days, err := db.SplitShard(merged.Name)
+		if err != nil {
+			t.Fatalf("Error splitting shard: %s", err)
+		}
+		if len(days) != 7 || days[0].Name != "2017_274.ts" || days[0].Records != 1 {
+			t.Fatalf("Got %d shards", len(days))
+		}
+		if count := countRecords(t, db, start, week.AddDate(0, 0, 2)); count != 8 {
+			t.Fatalf("Got %d records wanted 8", count)
+		}
+
+		halves, err := db.SplitShard("2017_274.ts", start.Add(12*time.Hour))
+		if err != nil {
+			t.Fatalf("Error splitting shard: %s", err)
+		}
+		if len(halves) != 2 || halves[0].Records != 0 || halves[1].Records != 1 {
+			t.Fatalf("Got %#v", halves)
+		}
+		if count := countRecords(t, db, start, week.AddDate(0, 0, 2)); count != 8 {
+			t.Fatalf("Got %d records wanted 8", count)
+		}
+
+		if _, err := db.MergeShards(start, week.AddDate(0, 0, 2), ""); err == nil {
+			t.Fatalf("the active shard is merged")
+		}
+	})
+}
+
+func TestListMergedAndSplitShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+	writeDays(t, db, start.Add(12*time.Hour), 8)
+	week := start.AddDate(0, 0, 7)
+
+	if _, err := db.MergeShards(start, week, ""); err != nil {
+		t.Fatalf("Error merging shards: %s", err)
+	}
+	shards, err := borm.ListShards(dir, time.UTC)
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 2 || !shards[1].StartTime().Equal(start) || !shards[1].EndTime().Equal(week) {
+		t.Fatalf("Got %d shards", len(shards))
+	}
+
+	if _, err := db.SplitShard("2017_274-2017_280.ts"); err != nil {
+		t.Fatalf("Error splitting shard: %s", err)
+	}
+	if _, err := db.SplitShard("2017_274.ts", start.Add(12*time.Hour)); err != nil {
+		t.Fatalf("Error splitting shard: %s", err)
+	}
+	shards, err = borm.ListShards(dir, time.UTC)
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 9 || !shards[7].StartTime().Equal(start.Add(12*time.Hour)) {
+		t.Fatalf("Got %d shards", len(shards))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing engine: %s", err)
+	}
+
+	// the two halves of the first day and the next two days are removed.
+	if err := borm.EnforceRetention(dir, start.AddDate(0, 0, 3)); err != nil {
+		t.Fatalf("Error enforcing retention: %s", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ts"))
+	if len(files) != 5 {
+		t.Fatalf("Got %d files wanted 5", len(files))
+	}
+}
@@ -0,0 +1,276 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// MsgpackEncode is a encoding func for borm (MessagePack), the fields of structs
+// are named by the msgpack or the json tag, time.Time is the timestamp extension.
+func MsgpackEncode(value interface{}) ([]byte, error) {
+	w := &msgpackWriter{}
+	if err := encodeBinary(w, "msgpack", reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// MsgpackDecode is a decoding func for borm (MessagePack)
+func MsgpackDecode(data []byte, value interface{}) error {
+	return decodeBinary(&msgpackReader{binaryData{data: data}}, "msgpack", value)
+}
+
+type msgpackWriter struct {
+	buf     bytes.Buffer
+	scratch [8]byte
+}
+
+func (w *msgpackWriter) writeHead(code byte, size int, u uint64) {
+	w.buf.WriteByte(code)
+	w.writeRaw(size, u)
+}
+
+// writeRaw writes the size bytes of u in big endian.
+func (w *msgpackWriter) writeRaw(size int, u uint64) {
+	for i := size - 1; i >= 0; i-- {
+		w.scratch[i] = byte(u)
+		u >>= 8
+	}
+	w.buf.Write(w.scratch[:size])
+}
+
+func (w *msgpackWriter) writeNil() {
+	w.buf.WriteByte(0xc0)
+}
+
+func (w *msgpackWriter) writeBool(b bool) {
+	if b {
+		w.buf.WriteByte(0xc3)
+	} else {
+		w.buf.WriteByte(0xc2)
+	}
+}
+
+func (w *msgpackWriter) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		w.writeUint(uint64(i))
+	case i >= -32:
+		w.buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		w.writeHead(0xd0, 1, uint64(i))
+	case i >= math.MinInt16:
+		w.writeHead(0xd1, 2, uint64(i))
+	case i >= math.MinInt32:
+		w.writeHead(0xd2, 4, uint64(i))
+	default:
+		w.writeHead(0xd3, 8, uint64(i))
+	}
+}
+
+func (w *msgpackWriter) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		w.buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		w.writeHead(0xcc, 1, u)
+	case u <= math.MaxUint16:
+		w.writeHead(0xcd, 2, u)
+	case u <= math.MaxUint32:
+		w.writeHead(0xce, 4, u)
+	default:
+		w.writeHead(0xcf, 8, u)
+	}
+}
+
+func (w *msgpackWriter) writeFloat32(f float32) {
+	w.writeHead(0xca, 4, uint64(math.Float32bits(f)))
+}
+
+func (w *msgpackWriter) writeFloat64(f float64) {
+	w.writeHead(0xcb, 8, math.Float64bits(f))
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	n := uint64(len(s))
+	switch {
+	case n < 32:
+		w.buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		w.writeHead(0xd9, 1, n)
+	case n <= math.MaxUint16:
+		w.writeHead(0xda, 2, n)
+	default:
+		w.writeHead(0xdb, 4, n)
+	}
+	w.buf.WriteString(s)
+}
+
+func (w *msgpackWriter) writeBytes(bs []byte) {
+	n := uint64(len(bs))
+	switch {
+	case n <= math.MaxUint8:
+		w.writeHead(0xc4, 1, n)
+	case n <= math.MaxUint16:
+		w.writeHead(0xc5, 2, n)
+	default:
+		w.writeHead(0xc6, 4, n)
+	}
+	w.buf.Write(bs)
+}
+
+func (w *msgpackWriter) writeArrayHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		w.writeHead(0xdc, 2, uint64(n))
+	default:
+		w.writeHead(0xdd, 4, uint64(n))
+	}
+}
+
+func (w *msgpackWriter) writeMapHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		w.writeHead(0xde, 2, uint64(n))
+	default:
+		w.writeHead(0xdf, 4, uint64(n))
+	}
+}
+
+// writeTime writes the timestamp extension (type -1) in its smallest format.
+func (w *msgpackWriter) writeTime(t time.Time) {
+	sec, nsec := uint64(t.Unix()), uint64(t.Nanosecond())
+	switch {
+	case sec>>32 == 0 && nsec == 0:
+		w.buf.Write([]byte{0xd6, 0xff})
+		w.writeRaw(4, sec)
+	case sec>>34 == 0:
+		w.buf.Write([]byte{0xd7, 0xff})
+		w.writeRaw(8, nsec<<34|sec)
+	default:
+		w.buf.Write([]byte{0xc7, 12, 0xff})
+		w.writeRaw(4, nsec)
+		w.writeRaw(8, sec)
+	}
+}
+
+type msgpackReader struct {
+	binaryData
+}
+
+func (r *msgpackReader) readSized(kind tokenKind, size int) (token, error) {
+	n, err := r.readUint(size)
+	if err != nil {
+		return token{}, err
+	}
+	if kind == tokenArray || kind == tokenMap {
+		return token{kind: kind, n: int(n)}, nil
+	}
+	s, err := r.read(int(n))
+	return token{kind: kind, s: s}, err
+}
+
+func (r *msgpackReader) readToken() (token, error) {
+	bs, err := r.read(1)
+	if err != nil {
+		return token{}, err
+	}
+	code := bs[0]
+
+	switch {
+	case code <= 0x7f:
+		return token{kind: tokenUint, u: uint64(code)}, nil
+	case code >= 0xe0:
+		return token{kind: tokenInt, i: int64(int8(code))}, nil
+	case code&0xf0 == 0x80:
+		return token{kind: tokenMap, n: int(code & 0x0f)}, nil
+	case code&0xf0 == 0x90:
+		return token{kind: tokenArray, n: int(code & 0x0f)}, nil
+	case code&0xe0 == 0xa0:
+		s, err := r.read(int(code & 0x1f))
+		return token{kind: tokenString, s: s}, err
+	}
+
+	switch code {
+	case 0xc0:
+		return token{kind: tokenNil}, nil
+	case 0xc2, 0xc3:
+		return token{kind: tokenBool, b: code == 0xc3}, nil
+	case 0xc4, 0xc5, 0xc6:
+		return r.readSized(tokenBytes, 1<<(code-0xc4))
+	case 0xd9, 0xda, 0xdb:
+		return r.readSized(tokenString, 1<<(code-0xd9))
+	case 0xdc, 0xdd:
+		return r.readSized(tokenArray, 2<<(code-0xdc))
+	case 0xde, 0xdf:
+		return r.readSized(tokenMap, 2<<(code-0xde))
+	case 0xca:
+		u, err := r.readUint(4)
+		return token{kind: tokenFloat, f: float64(math.Float32frombits(uint32(u)))}, err
+	case 0xcb:
+		u, err := r.readUint(8)
+		return token{kind: tokenFloat, f: math.Float64frombits(u)}, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := r.readUint(1 << (code - 0xcc))
+		return token{kind: tokenUint, u: u}, err
+	case 0xd0:
+		u, err := r.readUint(1)
+		return token{kind: tokenInt, i: int64(int8(u))}, err
+	case 0xd1:
+		u, err := r.readUint(2)
+		return token{kind: tokenInt, i: int64(int16(u))}, err
+	case 0xd2:
+		u, err := r.readUint(4)
+		return token{kind: tokenInt, i: int64(int32(u))}, err
+	case 0xd3:
+		u, err := r.readUint(8)
+		return token{kind: tokenInt, i: int64(u)}, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.readExt(1 << (code - 0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.readUint(1 << (code - 0xc7))
+		if err != nil {
+			return token{}, err
+		}
+		return r.readExt(int(n))
+	}
+	return token{}, errors.New("msgpack code " + strconv.FormatUint(uint64(code), 16) + " is unknown")
+}
+
+func (r *msgpackReader) readExt(size int) (token, error) {
+	typ, err := r.read(1)
+	if err != nil {
+		return token{}, err
+	}
+	bs, err := r.read(size)
+	if err != nil {
+		return token{}, err
+	}
+	if int8(typ[0]) != -1 {
+		return token{}, errors.New("msgpack extension " + strconv.Itoa(int(int8(typ[0]))) + " is unknown")
+	}
+
+	var sec, nsec int64
+	switch size {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(bs))
+	case 8:
+		u := binary.BigEndian.Uint64(bs)
+		sec, nsec = int64(u&(1<<34-1)), int64(u>>34)
+	case 12:
+		nsec = int64(binary.BigEndian.Uint32(bs))
+		sec = int64(binary.BigEndian.Uint64(bs[4:]))
+	default:
+		return token{}, errors.New("msgpack timestamp is invalid")
+	}
+	return token{kind: tokenTime, t: time.Unix(sec, nsec).UTC()}, nil
+}
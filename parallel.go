@@ -0,0 +1,223 @@
+package borm
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ParallelOptions are the options of QueryParallel
+type ParallelOptions struct {
+	// Workers is the number of shards scanned concurrently, defaults to the number of CPUs.
+	Workers int
+	// Buffer is the number of records buffered per shard, defaults to 256.
+	Buffer int
+	// Unordered passes records to the callback in the order they are read,
+	// it is faster for aggregations which don't depend on the order.
+	Unordered bool
+}
+
+// KeyValue is a record copied out of a shard.
+type KeyValue struct {
+	B     *Bucket
+	Key   []byte
+	Value []byte
+}
+
+// Read decodes the value into value with the decoder of the bucket.
+func (kv *KeyValue) Read(value interface{}) error {
+	return kv.B.corruptError(kv.Key, kv.B.decode(kv.Value, value))
+}
+
+// ReadWith decodes the value into value with decoder.
+func (kv *KeyValue) ReadWith(value interface{}, decoder DecodeFunc) error {
+	return kv.B.corruptError(kv.Key, decoder(kv.Value, value))
+}
+
+type shardScan struct {
+	position int
+	fileName string
+	out      chan *KeyValue
+	err      error
+}
+
+// overlapGroups splits the visits into the groups of shards whose time ranges
+// overlap, the records of a group are merged and the groups are concatenated.
+func overlapGroups(visits []shardVisit) [][]int {
+	var groups [][]int
+	var end time.Time
+	for idx, v := range visits {
+		if len(groups) == 0 || !v.start.Before(end) {
+			groups = append(groups, nil)
+			end = v.end
+		} else if v.end.After(end) {
+			end = v.end
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], idx)
+	}
+	return groups
+}
+
+// QueryParallel is same as Query, but the shards between start and end are scanned
+// concurrently by a bounded pool of workers. Records are merged back in key order
+// unless opts.Unordered is true, cb is always called from the calling goroutine.
+// The shards whose time ranges overlap, such as a merged shard and a daily shard,
+// are scanned at the same time to be merged, so Workers is raised to the number
+// of them. All workers are stopped when ctx is canceled or cb returns a error.
+func (db *TSEngine) QueryParallel(ctx context.Context, start, end time.Time, opts ParallelOptions, cb func(kv *KeyValue) error) error {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 256
+	}
+
+	visits, err := db.plan(start, end, false, false)
+	if err != nil || len(visits) == 0 {
+		return err
+	}
+	scans := make([]*shardScan, len(visits))
+	for idx, v := range visits {
+		scans[idx] = &shardScan{position: v.position, fileName: v.file}
+	}
+	groups := overlapGroups(visits)
+	for _, group := range groups {
+		if !opts.Unordered && opts.Workers < len(group) {
+			opts.Workers = len(group)
+		}
+	}
+	if opts.Workers > len(scans) {
+		opts.Workers = len(scans)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	var shared chan *KeyValue
+	if opts.Unordered {
+		shared = make(chan *KeyValue, opts.Buffer)
+	}
+
+	pending := make(chan *shardScan, len(scans))
+	for _, scan := range scans {
+		if opts.Unordered {
+			scan.out = shared
+		} else {
+			scan.out = make(chan *KeyValue, opts.Buffer)
+		}
+		pending <- scan
+	}
+	close(pending)
+
+	startID := CreateID(start, 0)
+	endID := CreateID(end, 0)
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for scan := range pending {
+				scan.err = db.scanShard(ctx, scan, startID, endID)
+				if !opts.Unordered {
+					close(scan.out)
+				}
+			}
+		}()
+	}
+
+	if opts.Unordered {
+		go func() {
+			wg.Wait()
+			close(shared)
+		}()
+
+		for kv := range shared {
+			if err := cb(kv); err != nil {
+				return err
+			}
+		}
+		for _, scan := range scans {
+			if scan.err != nil {
+				return scan.err
+			}
+		}
+		return ctx.Err()
+	}
+
+	for _, group := range groups {
+		merged := make([]*shardScan, len(group))
+		for idx, i := range group {
+			merged[idx] = scans[i]
+		}
+		if err := mergeScans(merged, cb); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// mergeScans calls cb with the records of scans in key order, the keys start with
+// the time of the records.
+func mergeScans(scans []*shardScan, cb func(kv *KeyValue) error) error {
+	heads := make([]*KeyValue, len(scans))
+	next := func(idx int) error {
+		kv, ok := <-scans[idx].out
+		if !ok {
+			heads[idx] = nil
+			return scans[idx].err
+		}
+		heads[idx] = kv
+		return nil
+	}
+	for idx := range scans {
+		if err := next(idx); err != nil {
+			return err
+		}
+	}
+
+	for {
+		min := -1
+		for idx, kv := range heads {
+			if kv != nil && (min < 0 || bytes.Compare(kv.Key, heads[min].Key) < 0) {
+				min = idx
+			}
+		}
+		if min < 0 {
+			return nil
+		}
+		if err := cb(heads[min]); err != nil {
+			return err
+		}
+		if err := next(min); err != nil {
+			return err
+		}
+	}
+}
+
+func (db *TSEngine) scanShard(ctx context.Context, scan *shardScan, startID, endID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.view(scan.fileName, func(bkt *Bucket) error {
+		return getRange(bkt, scan.position, false, startID, endID, func(it *Iterator) error {
+			for it.Next() {
+				kv := &KeyValue{
+					B:     bkt,
+					Key:   append([]byte(nil), it.Key()...),
+					Value: append([]byte(nil), it.Value()...),
+				}
+				select {
+				case scan.out <- kv:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,174 @@
+package borm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestQueryParallel(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+		for d := 0; d < 10; d++ {
+			for i := 0; i < 5; i++ {
+				at := start.AddDate(0, 0, d).Add(time.Duration(i) * time.Hour)
+				err := db.Write(at, func(bkt *borm.Bucket) error {
+					return bkt.Insert(borm.CreateID(at, 0), &sample{Value: float64(d*5 + i)})
+				})
+				if err != nil {
+					t.Fatalf("Error writing: %s", err)
+				}
+			}
+		}
+		end := start.AddDate(0, 0, 10)
+
+		var ordered []float64
+		err := db.QueryParallel(context.Background(), start, end, borm.ParallelOptions{Workers: 4, Buffer: 1},
+			func(kv *borm.KeyValue) error {
+				var s sample
+				if err := kv.Read(&s); err != nil {
+					return err
+				}
+				ordered = append(ordered, s.Value)
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if len(ordered) != 50 {
+			t.Fatalf("Got %d records wanted 50", len(ordered))
+		}
+		for idx, v := range ordered {
+			if v != float64(idx) {
+				t.Fatalf("Got %v at %d", v, idx)
+			}
+		}
+
+		var unordered []string
+		err = db.QueryParallel(context.Background(), start, end, borm.ParallelOptions{Workers: 4, Unordered: true},
+			func(kv *borm.KeyValue) error {
+				unordered = append(unordered, string(kv.Key))
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		sort.Strings(unordered)
+		for idx := 1; idx < len(unordered); idx++ {
+			if unordered[idx-1] == unordered[idx] {
+				t.Fatalf("record %s is duplicated", unordered[idx])
+			}
+		}
+		if len(unordered) != 50 {
+			t.Fatalf("Got %d records wanted 50", len(unordered))
+		}
+
+		stop := errors.New("stop")
+		var count int
+		err = db.QueryParallel(context.Background(), start, end, borm.ParallelOptions{Workers: 4, Buffer: 1},
+			func(kv *borm.KeyValue) error {
+				count++
+				if count == 3 {
+					return stop
+				}
+				return nil
+			})
+		if err != stop || count != 3 {
+			t.Fatalf("Got %v after %d records wanted %v after 3", err, count, stop)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = db.QueryParallel(ctx, start, end, borm.ParallelOptions{}, func(kv *borm.KeyValue) error {
+			return nil
+		})
+		if err != context.Canceled {
+			t.Fatalf("Got %v wanted %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestQueryParallelOverlapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(path string, hours ...int) *borm.TSEngine {
+		db, err := borm.OpenTS(path)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", path, err)
+		}
+		start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+		for d := 0; d < 3; d++ {
+			for _, h := range hours {
+				at := start.AddDate(0, 0, d).Add(time.Duration(h) * time.Hour)
+				err := db.Write(at, func(bkt *borm.Bucket) error {
+					return bkt.Insert(borm.CreateID(at, 0), &sample{Value: float64(d*24 + h)})
+				})
+				if err != nil {
+					t.Fatalf("Error writing: %s", err)
+				}
+			}
+		}
+		return db
+	}
+
+	// a merged shard of the first 2 days overlaps the daily shards.
+	merged := write(filepath.Join(dir, "merged"), 6, 18)
+	start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := merged.MergeShards(start, start.AddDate(0, 0, 2), ""); err != nil {
+		t.Fatalf("Error merging shards: %s", err)
+	}
+	if err := merged.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	daily := write(filepath.Join(dir, "daily"), 12)
+	if err := daily.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "merged", "2017_274-2017_275.ts"))
+	if err != nil {
+		t.Fatalf("Error reading merged shard: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "daily", "2017_274-2017_275.ts"), data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "daily", ".manifest.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := borm.OpenTS(filepath.Join(dir, "daily"))
+	if err != nil {
+		t.Fatalf("Error opening: %s", err)
+	}
+	defer db.Close()
+	if len(db.Shards()) != 4 {
+		t.Fatalf("Got %d shards wanted 4", len(db.Shards()))
+	}
+
+	var values []float64
+	err = db.QueryParallel(context.Background(), start, start.AddDate(0, 0, 3), borm.ParallelOptions{Workers: 1, Buffer: 1},
+		func(kv *borm.KeyValue) error {
+			var s sample
+			if err := kv.Read(&s); err != nil {
+				return err
+			}
+			values = append(values, s.Value)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if len(values) != 7 || !sort.Float64sAreSorted(values) {
+		t.Fatalf("Got %v wanted 7 records in order", values)
+	}
+}
@@ -0,0 +1,96 @@
+package borm
+
+import (
+	"errors"
+
+	"github.com/boltdb/bolt"
+)
+
+// RawEncode is a encoding func which stores []byte and string values as is, it
+// is for the buckets of already serialized payloads.
+func RawEncode(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case *[]byte:
+		return *v, nil
+	case string:
+		return []byte(v), nil
+	case *string:
+		return []byte(*v), nil
+	}
+	return nil, errors.New("raw value must be []byte or string")
+}
+
+// RawDecode is the decoding func of RawEncode, value is *[]byte or *string
+// and the data is copied.
+func RawDecode(data []byte, value interface{}) error {
+	switch v := value.(type) {
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	}
+	return errors.New("raw value must be *[]byte or *string")
+}
+
+// PutRaw stores value under key without the encoding func of the bucket.
+func (b *Bucket) PutRaw(key string, value []byte) error {
+	return b.store.db.Update(func(tx *bolt.Tx) error {
+		if !tx.Writable() {
+			return bolt.ErrTxNotWritable
+		}
+		bkt := tx.Bucket(b.name)
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+
+		return bkt.Put([]byte(key), value)
+	})
+}
+
+// GetRaw returns a copy of the value of key without the decoding func of the bucket.
+func (b *Bucket) GetRaw(key string) ([]byte, error) {
+	var value []byte
+	err := b.ViewRaw(key, func(data []byte) error {
+		value = append([]byte(nil), data...)
+		return nil
+	})
+	return value, err
+}
+
+// ViewRaw calls cb with the value of key, the value refers to the memory map
+// of the store, it is only valid in cb and must not be modified.
+func (b *Bucket) ViewRaw(key string, cb func(value []byte) error) error {
+	return b.store.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(b.name)
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+
+		value := bkt.Get([]byte(key))
+		if value == nil {
+			return ErrNotFound
+		}
+		return cb(value)
+	})
+}
+
+// ForEachRaw calls cb with the keys and values in the range between start and end
+// in order, the keys and values are only valid in cb as ViewRaw. An empty start or
+// end is unbounded.
+func (b *Bucket) ForEachRaw(start, end string, cb func(key, value []byte) error) error {
+	return b.GetRange(start, end, func(it *Iterator) error {
+		for it.Next() {
+			if it.Value() == nil {
+				continue
+			}
+			if err := cb(it.Key(), it.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
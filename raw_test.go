@@ -0,0 +1,92 @@
+package borm_test
+
+import (
+	"testing"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestRawBucket(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", borm.RawEncode, borm.RawDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+
+		if err := bkt.Insert("a", []byte("blob a")); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+		if err := bkt.Insert("b", "blob b"); err != nil {
+			t.Fatalf("Error inserting: %s", err)
+		}
+		if err := bkt.PutRaw("c", []byte("blob c")); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+		if err := bkt.Insert("d", 1); err == nil {
+			t.Fatal("int is encoded by RawEncode")
+		}
+
+		var s string
+		if err := bkt.Get("a", &s); err != nil || s != "blob a" {
+			t.Fatalf("Got %q, %v wanted 'blob a'", s, err)
+		}
+		var bs []byte
+		if err := bkt.Get("b", &bs); err != nil || string(bs) != "blob b" {
+			t.Fatalf("Got %q, %v wanted 'blob b'", bs, err)
+		}
+
+		value, err := bkt.GetRaw("c")
+		if err != nil || string(value) != "blob c" {
+			t.Fatalf("Got %q, %v wanted 'blob c'", value, err)
+		}
+		if _, err := bkt.GetRaw("x"); err != borm.ErrNotFound {
+			t.Fatalf("Got %v wanted ErrNotFound", err)
+		}
+
+		var keys []string
+		err = bkt.ForEachRaw("b", "", func(key, value []byte) error {
+			keys = append(keys, string(key)+"="+string(value))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error reading: %s", err)
+		}
+		if len(keys) != 2 || keys[0] != "b=blob b" || keys[1] != "c=blob c" {
+			t.Fatalf("Got %v", keys)
+		}
+	})
+}
+
+func TestRawGob(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		// a payload encoded by the caller isn't encoded again.
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		data, err := borm.DefaultEncode(&ItemTest{Name: "raw"})
+		if err != nil {
+			t.Fatalf("Error encoding: %s", err)
+		}
+		if err := bkt.PutRaw("a", data); err != nil {
+			t.Fatalf("Error putting: %s", err)
+		}
+
+		result := &ItemTest{}
+		if err := bkt.Get("a", result); err != nil {
+			t.Fatalf("Error getting: %s", err)
+		}
+		if result.Name != "raw" {
+			t.Fatalf("Got %v", result)
+		}
+
+		var size int
+		err = bkt.ViewRaw("a", func(value []byte) error {
+			size = len(value)
+			return nil
+		})
+		if err != nil || size != len(data) {
+			t.Fatalf("Got %d, %v wanted %d", size, err, len(data))
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package borm
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes which shards are removed by the retention manager,
+// a zero limit is disabled. The newest shard is never removed by MaxSize or
+// MaxShards, the shards open for writing are closed before removed by MaxAge.
+type RetentionPolicy struct {
+	// MaxAge removes the shards whose time range ends before now - MaxAge.
+	MaxAge time.Duration
+	// MaxSize removes the oldest shards until the total size of shard files isn't greater than MaxSize.
+	MaxSize int64
+	// MaxShards removes the oldest shards until there are at most MaxShards shards.
+	MaxShards int
+
+	// Archive compacts and compresses the selected shards into the archive
+	// directory instead of removing them, see ArchiveBefore.
+	Archive bool
+	// Interval is the period of the background goroutine, defaults to one hour.
+	Interval time.Duration
+	// BeforeRemove is called before a shard is removed, the run is stopped if it
+	// returns a error. It is called without the engine locked, the shards which
+	// are opened for writing meanwhile are kept.
+	BeforeRemove func(shard *Shard) error
+	// OnReport is called after each background run, errors are logged if it is nil.
+	OnReport func(report *RetentionReport, err error)
+}
+
+// RetentionReport is the result of a retention run, Removed lists the archived
+// shards if the policy is in archive mode.
+type RetentionReport struct {
+	Time        time.Time
+	DryRun      bool
+	Removed     Shards
+	RemovedSize int64
+	Kept        Shards
+	KeptSize    int64
+}
+
+type retentionWorker struct {
+	closed chan struct{}
+	done   chan struct{}
+}
+
+func isCurrentShard(shard *Shard, currentFile string) bool {
+	return strings.ToLower(filepath.Base(shard.path)) ==
+		strings.ToLower(filepath.Base(currentFile))
+}
+
+// ApplyRetention removes the shards selected by policy at now, if dryRun is true
+// shards aren't removed and the report lists what would have been removed.
+func (db *TSEngine) ApplyRetention(policy RetentionPolicy, now time.Time, dryRun bool) (*RetentionReport, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.applyRetention(policy, now, dryRun)
+}
+
+// retentionVictim is a shard selected by a retention run, open is true if the
+// shard is open for writing when it is selected.
+type retentionVictim struct {
+	shard *Shard
+	size  int64
+	open  bool
+}
+
+func (db *TSEngine) applyRetention(policy RetentionPolicy, now time.Time, dryRun bool) (*RetentionReport, error) {
+	if err := db.flushBuffer(); err != nil {
+		return nil, err
+	}
+
+	shards := db.shards()
+	report := &RetentionReport{Time: now, DryRun: dryRun}
+
+	// shards are ordered by decreasing time, so the newest shards are kept first.
+	var victims []retentionVictim
+	var total int64
+	var count int
+	for _, shard := range shards {
+		fi, err := os.Stat(shard.path)
+		if err != nil {
+			return nil, err
+		}
+		total += fi.Size()
+		count++
+
+		remove := policy.MaxAge > 0 && !shard.endTime.After(now.Add(-policy.MaxAge))
+		if !isCurrentShard(shard, db.currentFile) {
+			if policy.MaxSize > 0 && total > policy.MaxSize {
+				remove = true
+			}
+			if policy.MaxShards > 0 && count > policy.MaxShards {
+				remove = true
+			}
+		}
+
+		if !remove {
+			report.Kept = append(report.Kept, shard)
+			report.KeptSize += fi.Size()
+			continue
+		}
+
+		// removed shards don't count against the limits of older shards.
+		total -= fi.Size()
+		count--
+
+		if dryRun {
+			report.Removed = append(report.Removed, shard)
+			report.RemovedSize += fi.Size()
+			continue
+		}
+		victims = append(victims, retentionVictim{shard: shard, size: fi.Size(), open: db.writers[shard.path] != nil})
+	}
+
+	// the hooks are called without the lock, so that they may call the engine.
+	var err error
+	if policy.BeforeRemove != nil && len(victims) > 0 {
+		db.mu.Unlock()
+		for idx, v := range victims {
+			if err = policy.BeforeRemove(v.shard); err != nil {
+				victims = victims[:idx]
+				break
+			}
+		}
+		db.mu.Lock()
+	}
+
+	for _, v := range victims {
+		// a shard which is removed meanwhile is skipped, and a shard which is
+		// opened for writing meanwhile is kept.
+		if !db.manifest.has(v.shard.path) {
+			continue
+		}
+		if !v.open && db.writers[v.shard.path] != nil {
+			report.Kept = append(report.Kept, v.shard)
+			report.KeptSize += v.size
+			continue
+		}
+
+		if e := db.closeFile(v.shard.path); e != nil {
+			return report, e
+		}
+		var e error
+		if policy.Archive {
+			e = db.archiveShard(v.shard)
+		} else {
+			e = db.removeShard(v.shard.path)
+		}
+		if e != nil {
+			return report, e
+		}
+		report.Removed = append(report.Removed, v.shard)
+		report.RemovedSize += v.size
+	}
+	return report, err
+}
+
+// StartRetention starts a background goroutine which applies policy periodically,
+// it is stopped by StopRetention or Close.
+func (db *TSEngine) StartRetention(policy RetentionPolicy) error {
+	if policy.MaxAge <= 0 && policy.MaxSize <= 0 && policy.MaxShards <= 0 {
+		return errors.New("retention policy is empty")
+	}
+	if policy.Interval <= 0 {
+		policy.Interval = time.Hour
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.retention != nil {
+		return errors.New("retention is already started")
+	}
+
+	w := &retentionWorker{
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	db.retention = w
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.closed:
+				return
+			case now := <-ticker.C:
+				report, err := db.ApplyRetention(policy, now, false)
+				if policy.OnReport != nil {
+					policy.OnReport(report, err)
+				} else if err != nil {
+					log.Printf("engine failed to apply retention: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopRetention stops the background retention goroutine and waits for it to exit.
+func (db *TSEngine) StopRetention() {
+	db.mu.Lock()
+	w := db.retention
+	db.retention = nil
+	db.mu.Unlock()
+
+	if w != nil {
+		close(w.closed)
+		<-w.done
+	}
+}
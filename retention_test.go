@@ -0,0 +1,154 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func writeDays(t *testing.T, db *borm.TSEngine, start time.Time, days int) {
+	for i := 0; i < days; i++ {
+		at := start.AddDate(0, 0, i)
+		err := db.Write(at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(at, uint32(i)), &sample{Value: float64(i)})
+		})
+		if err != nil {
+			t.Fatalf("Error writing day %d: %s", i, err)
+		}
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+		writeDays(t, db, start, 5)
+
+		report, err := db.ApplyRetention(borm.RetentionPolicy{MaxShards: 2}, start.AddDate(0, 0, 5), true)
+		if err != nil {
+			t.Fatalf("Error applying retention: %s", err)
+		}
+		if len(report.Removed) != 3 || len(report.Kept) != 2 {
+			t.Fatalf("Got %d removed and %d kept, wanted 3 and 2", len(report.Removed), len(report.Kept))
+		}
+		for _, shard := range report.Removed {
+			if _, err := os.Stat(shard.Path()); err != nil {
+				t.Fatalf("dry run removed %s", shard.Path())
+			}
+		}
+
+		var hooked int
+		report, err = db.ApplyRetention(borm.RetentionPolicy{
+			MaxAge: 48 * time.Hour,
+			BeforeRemove: func(shard *borm.Shard) error {
+				hooked++
+				return nil
+			},
+		}, start.AddDate(0, 0, 5), false)
+		if err != nil {
+			t.Fatalf("Error applying retention: %s", err)
+		}
+		if len(report.Removed) != 3 || hooked != 3 {
+			t.Fatalf("Got %d removed and %d hooked, wanted 3", len(report.Removed), hooked)
+		}
+		for _, shard := range report.Removed {
+			if _, err := os.Stat(shard.Path()); !os.IsNotExist(err) {
+				t.Fatalf("%s isn't removed", shard.Path())
+			}
+		}
+
+		// the active shard is kept by MaxShards.
+		report, err = db.ApplyRetention(borm.RetentionPolicy{MaxShards: 1}, start.AddDate(0, 0, 5), false)
+		if err != nil {
+			t.Fatalf("Error applying retention: %s", err)
+		}
+		if len(report.Kept) != 1 || !report.Kept[0].StartTime().Equal(time.Date(2017, 10, 5, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("Got %d kept", len(report.Kept))
+		}
+	})
+}
+
+func TestStartRetention(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		writeDays(t, db, time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC), 3)
+
+		reports := make(chan *borm.RetentionReport, 1)
+		err := db.StartRetention(borm.RetentionPolicy{
+			MaxShards: 1,
+			Interval:  10 * time.Millisecond,
+			OnReport: func(report *borm.RetentionReport, err error) {
+				if err != nil {
+					t.Errorf("Error applying retention: %s", err)
+				}
+				select {
+				case reports <- report:
+				default:
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error starting retention: %s", err)
+		}
+
+		select {
+		case report := <-reports:
+			if len(report.Removed) != 2 {
+				t.Fatalf("Got %d removed wanted 2", len(report.Removed))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("retention isn't run")
+		}
+		db.StopRetention()
+	})
+}
+
+func TestRetentionHookCallsEngine(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+		writeDays(t, db, start, 3)
+
+		var reopened string
+		report, err := db.ApplyRetention(borm.RetentionPolicy{
+			MaxShards: 1,
+			BeforeRemove: func(shard *borm.Shard) error {
+				// the hook is called without the engine locked.
+				var count int
+				err := db.Query(shard.StartTime(), shard.EndTime(), func(it *borm.Iterator) error {
+					for it.Next() {
+						count++
+					}
+					return nil
+				})
+				if err != nil || count != 1 {
+					t.Errorf("Got %d records, %v wanted 1", count, err)
+				}
+				if reopened != "" {
+					return nil
+				}
+
+				// the shard which is opened for writing by the hook is kept.
+				reopened = shard.Path()
+				if err := db.SetLateWritePolicy(borm.LateWritePolicy{Window: 72 * time.Hour}); err != nil {
+					return err
+				}
+				at := shard.StartTime().Add(time.Hour)
+				return db.Write(at, func(bkt *borm.Bucket) error {
+					return bkt.Insert(borm.CreateID(at, 0), &sample{Value: 1})
+				})
+			},
+		}, start.AddDate(0, 0, 3), false)
+		if err != nil {
+			t.Fatalf("Error applying retention: %s", err)
+		}
+		if len(report.Removed) != 1 || len(report.Kept) != 2 {
+			t.Fatalf("Got %d removed and %d kept, wanted 1 and 2", len(report.Removed), len(report.Kept))
+		}
+		if _, err := os.Stat(reopened); err != nil {
+			t.Fatalf("reopened shard is removed: %s", err)
+		}
+		if len(db.Shards()) != 2 {
+			t.Fatalf("Got %d shards wanted 2", len(db.Shards()))
+		}
+	})
+}
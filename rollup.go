@@ -0,0 +1,323 @@
+package borm
+
+import (
+	"errors"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// SeriesExtractor read the series name and the value from the current record of the iterator,
+// the record is skipped if series is empty.
+type SeriesExtractor func(it *Iterator) (series string, value float64, err error)
+
+// Rollup is a definition of downsampled aggregates, for example 1-minute or 1-hour
+// aggregates per series, the windows are aligned to the clock of the location of
+// the engine. Rollups are stored in separate files under the rollups
+// directory of the engine, they aren't removed by EnforceRetention.
+type Rollup struct {
+	Name      string
+	Interval  time.Duration
+	Extractor SeriesExtractor
+}
+
+// RollupPoint is a aggregated value of a series in a rollup window.
+type RollupPoint struct {
+	Start time.Time
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// Avg returns the average value of the window.
+func (p *RollupPoint) Avg() float64 {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Sum / float64(p.Count)
+}
+
+func (p *RollupPoint) add(value float64) {
+	if p.Count == 0 {
+		p.Min = value
+		p.Max = value
+	} else {
+		p.Min = math.Min(p.Min, value)
+		p.Max = math.Max(p.Max, value)
+	}
+	p.Sum += value
+	p.Count++
+}
+
+// AddRollup registers a rollup definition, it is computed in the background when
+// a shard is closed or on demand by ComputeRollups. The extractor is called
+// without the engine locked.
+func (db *TSEngine) AddRollup(r Rollup) error {
+	if r.Name == "" || r.Interval <= 0 || r.Extractor == nil {
+		return errors.New("rollup is invalid")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, old := range db.rollups {
+		if old.Name == r.Name {
+			return errors.New("rollup '" + r.Name + "' already exists")
+		}
+	}
+	// the rollups are copied so that the workers keep the old slice.
+	rollups := append(append([]*Rollup(nil), db.rollups...), &r)
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Interval < rollups[j].Interval
+	})
+	db.rollups = rollups
+	return nil
+}
+
+func (db *TSEngine) rollupFile(r *Rollup) string {
+	return filepath.Join(db.basePath, "rollups", r.Name+".db")
+}
+
+func (db *TSEngine) openRollup(r *Rollup) (*Store, error) {
+	file := db.rollupFile(r)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	return Open(file, 0666, &bolt.Options{Timeout: 10 * time.Second})
+}
+
+// ComputeRollups (re)computes all rollups for the windows that overlap start and end.
+func (db *TSEngine) ComputeRollups(start, end time.Time) error {
+	return db.computeRollups(start, end)
+}
+
+// computeRollups is called without the lock, the records are read as Query does.
+func (db *TSEngine) computeRollups(start, end time.Time) error {
+	db.mu.Lock()
+	rollups := db.rollups
+	db.mu.Unlock()
+
+	for _, r := range rollups {
+		if err := db.computeRollup(r, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *TSEngine) computeRollup(r *Rollup, start, end time.Time) error {
+	start, _ = alignWindow(start, r.Interval, db.loc)
+	_, end = alignWindow(end, r.Interval, db.loc)
+
+	points := map[string]map[int64]*RollupPoint{}
+	err := db.queryShards(start, end, false, func(it *Iterator) error {
+		for it.Next() {
+			t := TimeFromID(string(it.Key()))
+			if t.IsZero() || !t.Before(end) {
+				continue
+			}
+
+			series, value, err := r.Extractor(it)
+			if err != nil {
+				return err
+			}
+			if series == "" {
+				continue
+			}
+
+			windows := points[series]
+			if windows == nil {
+				windows = map[int64]*RollupPoint{}
+				points[series] = windows
+			}
+			windowStart, _ := alignWindow(t, r.Interval, db.loc)
+			p := windows[windowStart.Unix()]
+			if p == nil {
+				p = &RollupPoint{Start: windowStart}
+				windows[windowStart.Unix()] = p
+			}
+			p.add(value)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if len(points) == 0 {
+		return nil
+	}
+
+	db.rollupMu.Lock()
+	defer db.rollupMu.Unlock()
+
+	store, err := db.openRollup(r)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for series, windows := range points {
+		bkt, err := store.CreateBucketIfNotExists(series, nil, nil)
+		if err != nil {
+			return err
+		}
+		err = bkt.Write(func(u Updater) error {
+			for _, p := range windows {
+				if err := u.Upsert(CreateID(p.Start, 0), p); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollupTask is the time range of a closed shard whose rollups are computed by
+// the rollup worker.
+type rollupTask struct {
+	start, end time.Time
+}
+
+// rollupShard queues the rollups for the time range of a shard, they are computed
+// by a worker without the lock, so that the extractors don't stall the engine.
+func (db *TSEngine) rollupShard(bkt *Bucket) error {
+	if len(db.rollups) == 0 {
+		return nil
+	}
+
+	var first, last time.Time
+	err := bkt.ForEach(func(it *Iterator) error {
+		if k, _ := it.Cursor.First(); k != nil {
+			first = TimeFromID(string(k))
+		}
+		if k, _ := it.Cursor.Last(); k != nil {
+			last = TimeFromID(string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if first.IsZero() || last.IsZero() {
+		return nil
+	}
+
+	db.rollupTasks = append(db.rollupTasks, rollupTask{start: first, end: last})
+	if !db.rollupBusy {
+		db.rollupBusy = true
+		go db.runRollups()
+	}
+	return nil
+}
+
+// runRollups computes the queued rollups until the queue is empty.
+func (db *TSEngine) runRollups() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for len(db.rollupTasks) > 0 {
+		task := db.rollupTasks[0]
+		db.rollupTasks = db.rollupTasks[1:]
+
+		db.mu.Unlock()
+		err := db.computeRollups(task.start, task.end)
+		db.mu.Lock()
+		if err != nil {
+			log.Printf("engine failed to compute rollups: %s", err)
+		}
+	}
+	db.rollupBusy = false
+	db.idle.Broadcast()
+}
+
+// waitRollups waits until the queued rollups are computed.
+func (db *TSEngine) waitRollups() {
+	for db.rollupBusy {
+		db.idle.Wait()
+	}
+}
+
+// QueryRollup reads the points of series between start and end, it selects the
+// coarsest rollup whose interval isn't greater than step, or the finest rollup if
+// there is none.
+func (db *TSEngine) QueryRollup(series string, start, end time.Time, step time.Duration,
+	cb func(r *Rollup, p *RollupPoint) error) error {
+	r, points, err := db.readRollup(series, start, end, step)
+	if err != nil {
+		return err
+	}
+
+	// the rollups are updated by the writes, so cb is called after they are read.
+	for idx := range points {
+		if err := cb(r, &points[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *TSEngine) readRollup(series string, start, end time.Time, step time.Duration) (*Rollup, []RollupPoint, error) {
+	db.mu.Lock()
+	rollups := db.rollups
+	db.mu.Unlock()
+
+	if len(rollups) == 0 {
+		return nil, nil, errors.New("no rollup is defined")
+	}
+	if start.After(end) {
+		return nil, nil, errors.New("time range is invalid")
+	}
+
+	r := rollups[0]
+	for _, rollup := range rollups {
+		if rollup.Interval <= step {
+			r = rollup
+		}
+	}
+
+	db.rollupMu.Lock()
+	defer db.rollupMu.Unlock()
+
+	if _, err := os.Stat(db.rollupFile(r)); os.IsNotExist(err) {
+		return r, nil, nil
+	}
+	store, err := db.openRollup(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer store.Close()
+
+	bkt, err := store.GetBucket(series, nil, nil)
+	if err != nil {
+		if err == ErrBucketNotFound {
+			return r, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var points []RollupPoint
+	first, _ := alignWindow(start, r.Interval, db.loc)
+	startID := CreateID(first, 0)
+	endID := CreateID(end, 0)
+	err = bkt.GetRange(startID, endID, func(it *Iterator) error {
+		for it.Next() {
+			var p RollupPoint
+			if err := it.Read(&p); err != nil {
+				return err
+			}
+			points = append(points, p)
+		}
+		return nil
+	})
+	return r, points, err
+}
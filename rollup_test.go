@@ -0,0 +1,127 @@
+package borm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+type seriesSample struct {
+	Series string
+	Value  float64
+}
+
+func TestRollup(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		extractor := func(it *borm.Iterator) (string, float64, error) {
+			var s seriesSample
+			err := it.Read(&s)
+			return s.Series, s.Value, err
+		}
+		for _, r := range []borm.Rollup{
+			{Name: "1h", Interval: time.Hour, Extractor: extractor},
+			{Name: "1m", Interval: time.Minute, Extractor: extractor},
+		} {
+			if err := db.AddRollup(r); err != nil {
+				t.Fatalf("Error adding rollup %s: %s", r.Name, err)
+			}
+		}
+
+		start := time.Date(2017, 10, 11, 23, 58, 0, 0, time.UTC)
+		for i := 0; i < 8; i++ {
+			at := start.Add(time.Duration(i) * 30 * time.Second)
+			err := db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, uint32(i)), &seriesSample{Series: "cpu", Value: float64(i)})
+			})
+			if err != nil {
+				t.Fatalf("Error writing sample %d: %s", i, err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Error closing engine: %s", err)
+		}
+
+		var minutes []borm.RollupPoint
+		err := db.QueryRollup("cpu", start, start.Add(time.Hour), time.Minute, func(r *borm.Rollup, p *borm.RollupPoint) error {
+			if r.Name != "1m" {
+				t.Fatalf("Got rollup %s wanted 1m", r.Name)
+			}
+			minutes = append(minutes, *p)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying rollup: %s", err)
+		}
+		if len(minutes) != 4 {
+			t.Fatalf("Got %d points wanted 4", len(minutes))
+		}
+		for idx, p := range minutes {
+			if p.Count != 2 || p.Min != float64(2*idx) || p.Max != float64(2*idx+1) {
+				t.Fatalf("point %d: got %#v", idx, p)
+			}
+		}
+
+		var hours []borm.RollupPoint
+		err = db.QueryRollup("cpu", start, start.Add(time.Hour), 2*time.Hour, func(r *borm.Rollup, p *borm.RollupPoint) error {
+			hours = append(hours, *p)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying rollup: %s", err)
+		}
+		if len(hours) != 2 || hours[0].Count != 4 || hours[1].Count != 4 {
+			t.Fatalf("Got %#v", hours)
+		}
+		if hours[1].Avg() != 5.5 {
+			t.Fatalf("Got avg %v wanted 5.5", hours[1].Avg())
+		}
+	})
+}
+
+func TestRollupExtractorCallsEngine(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		extractor := func(it *borm.Iterator) (string, float64, error) {
+			// the extractor is called without the engine locked.
+			if len(db.Shards()) == 0 {
+				t.Error("no shard is found")
+			}
+			var s seriesSample
+			err := it.Read(&s)
+			return s.Series, s.Value, err
+		}
+		if err := db.AddRollup(borm.Rollup{Name: "1h", Interval: time.Hour, Extractor: extractor}); err != nil {
+			t.Fatalf("Error adding rollup: %s", err)
+		}
+
+		// the shard of the first day is closed by the write of the second day.
+		start := time.Date(2017, 10, 11, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 2; i++ {
+			at := start.AddDate(0, 0, i)
+			err := db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, 0), &seriesSample{Series: "cpu", Value: float64(i)})
+			})
+			if err != nil {
+				t.Fatalf("Error writing sample %d: %s", i, err)
+			}
+		}
+		if err := db.ComputeRollups(start.AddDate(0, 0, 1), start.AddDate(0, 0, 1)); err != nil {
+			t.Fatalf("Error computing rollups: %s", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Error closing engine: %s", err)
+		}
+
+		var points []borm.RollupPoint
+		err := db.QueryRollup("cpu", start, start.AddDate(0, 0, 2), time.Hour, func(r *borm.Rollup, p *borm.RollupPoint) error {
+			points = append(points, *p)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying rollup: %s", err)
+		}
+		if len(points) != 2 || points[0].Sum != 0 || points[1].Sum != 1 {
+			t.Fatalf("Got %#v", points)
+		}
+	})
+}
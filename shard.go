@@ -3,7 +3,6 @@ package borm
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -18,6 +17,15 @@ type Shard struct {
 	startTime, endTime time.Time
 }
 
+// Path returns the file path of the shard.
+func (s *Shard) Path() string { return s.path }
+
+// StartTime returns the start of the time range covered by the shard.
+func (s *Shard) StartTime() time.Time { return s.startTime }
+
+// EndTime returns the end of the time range covered by the shard.
+func (s *Shard) EndTime() time.Time { return s.endTime }
+
 // Shards is a slice of Shards.
 type Shards []*Shard
 
@@ -33,30 +41,67 @@ func (i Shards) Less(u, v int) bool {
 }
 func (i Shards) Swap(u, v int) { i[u], i[v] = i[v], i[u] }
 
+// splitLayout is the layout of the start time in the names of split shards.
+const splitLayout = "20060102150405"
+
+// openShard parses the time range of a shard from its name, it is a day named by
+// tsName, the first and the last day of merged shards joined by "-", or a shard
+// name and the start time of a part split from it joined by "_". The end time of
+// a split part is the end time of the shard it is split from.
 func openShard(path string, loc *time.Location) (*Shard, error) {
 	name := filepath.Base(path)
 	idx := strings.IndexRune(name, '.')
 	if idx >= 0 {
 		name = name[:idx]
 	}
+	invalid := errors.New("invalid shard name - " + name)
+
+	base := name
+	var splitAt time.Time
+	if idx := strings.LastIndexByte(base, '_'); idx >= 0 && len(base)-idx-1 == len(splitLayout) {
+		t, err := time.ParseInLocation(splitLayout, base[idx+1:], loc)
+		if err != nil {
+			return nil, invalid
+		}
+		base, splitAt = base[:idx], t
+	}
+
+	first, last := base, base
+	if idx := strings.IndexByte(base, '-'); idx >= 0 {
+		first, last = base[:idx], base[idx+1:]
+	}
+	start, ok := parseShardDay(first, loc)
+	if !ok {
+		return nil, invalid
+	}
+	end, ok := parseShardDay(last, loc)
+	if !ok || end.Before(start) {
+		return nil, invalid
+	}
+	if !splitAt.IsZero() {
+		start = splitAt
+	}
+	return &Shard{path: path,
+		startTime: start,
+		endTime:   end.AddDate(0, 0, 1)}, nil
+}
 
+// parseShardDay parses a day named by tsName without the extension.
+func parseShardDay(name string, loc *time.Location) (time.Time, bool) {
 	ss := strings.Split(name, "_")
 	if len(ss) != 2 {
-		return nil, errors.New("invalid shard name - " + name)
+		return time.Time{}, false
 	}
 
 	year, err := strconv.Atoi(ss[0])
 	if err != nil {
-		return nil, errors.New("invalid shard name - " + name)
+		return time.Time{}, false
 	}
 	yearDay, err := strconv.Atoi(ss[1])
 	if err != nil {
-		return nil, errors.New("invalid shard name - " + name)
+		return time.Time{}, false
 	}
-	start := time.Date(year, time.January, 0, 0, 0, 0, 0, loc).AddDate(0, 0, yearDay)
-	return &Shard{path: path,
-		startTime: start,
-		endTime:   start.AddDate(0, 0, 1)}, nil
+	return time.Date(year, time.January, 0, 0, 0, 0, 0, loc).AddDate(0, 0, yearDay), true
 }
 
 func ListShards(path string, loc *time.Location) (Shards, error) {
@@ -89,10 +134,9 @@ func ListShards(path string, loc *time.Location) (Shards, error) {
 		if err != nil {
 			return nil, fmt.Errorf("engine failed to open at shard %s: %s", shardPath, err.Error())
 		}
-		log.Printf("engine opened shard at %s", shardPath)
 		shards = append(shards, shard)
-		sort.Sort(shards)
 	}
+	sort.Sort(shards)
 
 	return shards, nil
 }
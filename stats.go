@@ -0,0 +1,134 @@
+package borm
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ShardStats is the statistics of a shard returned by TSEngine.ShardStats
+type ShardStats struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+	State ShardState
+	// Size is the size of the file, it is the compressed size if the shard is archived.
+	Size int64
+
+	PageSize     int
+	DataSize     int64
+	FreePages    int
+	PendingPages int
+	FreeAlloc    int
+
+	Buckets []BucketStats
+}
+
+// BucketStats is the statistics of a bucket in a shard
+type BucketStats struct {
+	Name        string    `json:"name"`
+	Records     int       `json:"records"`
+	FirstKey    string    `json:"first_key,omitempty"`
+	LastKey     string    `json:"last_key,omitempty"`
+	FirstTime   time.Time `json:"first_time"`
+	LastTime    time.Time `json:"last_time"`
+	BranchPages int       `json:"branch_pages"`
+	LeafPages   int       `json:"leaf_pages"`
+	LeafInuse   int       `json:"leaf_inuse"`
+}
+
+// ShardStats returns the statistics of the shards which overlap the range between
+// start and end, they are ordered by start time. The statistics of the archived
+// shards are the ones recorded when they are archived, they aren't decompressed.
+func (db *TSEngine) ShardStats(start, end time.Time) ([]ShardStats, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushBuffer(); err != nil {
+		return nil, err
+	}
+
+	var results []ShardStats
+	for _, info := range db.manifest.overlapping(start, end) {
+		file := filepath.Join(db.basePath, info.Name)
+		stats := ShardStats{
+			Name:  info.Name,
+			Start: info.Start,
+			End:   info.End,
+			State: info.State,
+			Size:  info.Size,
+		}
+		if info.State == ShardArchived {
+			stats.PageSize = info.PageSize
+			stats.DataSize = info.DataSize
+			stats.Buckets = info.Buckets
+			results = append(results, stats)
+			continue
+		}
+
+		if fi, err := os.Stat(file); err == nil {
+			stats.Size = fi.Size()
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		err := db.read(file, func(bkt *Bucket) error {
+			return readStats(bkt.store.Bolt(), &stats)
+		})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, stats)
+	}
+	return results, nil
+}
+
+// readFileStats reads the statistics of the bolt file of a shard which isn't open.
+func readFileStats(file string, stats *ShardStats) error {
+	boltDB, err := bolt.Open(file, 0444, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer boltDB.Close()
+	return readStats(boltDB, stats)
+}
+
+func readStats(boltDB *bolt.DB, stats *ShardStats) error {
+	dbStats := boltDB.Stats()
+	stats.PageSize = boltDB.Info().PageSize
+	stats.FreePages = dbStats.FreePageN
+	stats.PendingPages = dbStats.PendingPageN
+	stats.FreeAlloc = dbStats.FreeAlloc
+
+	return boltDB.View(func(tx *bolt.Tx) error {
+		stats.DataSize = tx.Size()
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			stats.Buckets = append(stats.Buckets, readBucketStats(string(name), b))
+			return nil
+		})
+	})
+}
+
+func readBucketStats(name string, b *bolt.Bucket) BucketStats {
+	bs := b.Stats()
+	stats := BucketStats{
+		Name:        name,
+		Records:     bs.KeyN,
+		BranchPages: bs.BranchPageN,
+		LeafPages:   bs.LeafPageN,
+		LeafInuse:   bs.LeafInuse,
+	}
+
+	c := b.Cursor()
+	if k, _ := c.First(); k != nil {
+		stats.FirstKey = string(k)
+		stats.FirstTime = TimeFromID(stats.FirstKey)
+	}
+	if k, _ := c.Last(); k != nil {
+		stats.LastKey = string(k)
+		stats.LastTime = TimeFromID(stats.LastKey)
+	}
+	return stats
+}
@@ -0,0 +1,68 @@
+package borm_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestShardStats(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 10; i++ {
+			at := start.Add(time.Duration(i) * 6 * time.Hour)
+			err := db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, 0), &sample{Value: float64(i)})
+			})
+			if err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+
+		stats, err := db.ShardStats(start, start.AddDate(0, 0, 2))
+		if err != nil {
+			t.Fatalf("Error reading stats: %s", err)
+		}
+		if len(stats) != 2 {
+			t.Fatalf("Got %d shards wanted 2", len(stats))
+		}
+
+		first := stats[0]
+		if first.Name != "2017_274.ts" || first.State != borm.ShardSealed || first.Size == 0 || first.PageSize == 0 {
+			t.Fatalf("Got %#v", first)
+		}
+		if len(first.Buckets) != 1 || first.Buckets[0].Records != 4 {
+			t.Fatalf("Got %#v", first.Buckets)
+		}
+		if !first.Buckets[0].FirstTime.Equal(start) || !first.Buckets[0].LastTime.Equal(start.Add(18*time.Hour)) {
+			t.Fatalf("Got first %v and last %v", first.Buckets[0].FirstTime, first.Buckets[0].LastTime)
+		}
+
+		if stats[1].State != borm.ShardSealed || stats[1].Buckets[0].Records != 4 {
+			t.Fatalf("Got %#v", stats[1])
+		}
+
+		// the statistics of a archived shard are read from the manifest.
+		shards, err := db.ArchiveBefore(start.AddDate(0, 0, 1))
+		if err != nil || len(shards) != 1 {
+			t.Fatalf("Got %d archived, %v wanted 1", len(shards), err)
+		}
+		stats, err = db.ShardStats(start, start.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatalf("Error reading stats: %s", err)
+		}
+		archived := stats[0]
+		if archived.State != borm.ShardArchived || archived.Size == 0 || archived.PageSize == 0 || len(archived.Buckets) != 1 {
+			t.Fatalf("Got %#v", archived)
+		}
+		if archived.Buckets[0].Records != 4 || !archived.Buckets[0].LastTime.Equal(start.Add(18*time.Hour)) {
+			t.Fatalf("Got %#v", archived.Buckets[0])
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(shards[0].Path()), "archive", ".cache")); !os.IsNotExist(err) {
+			t.Fatalf("archived shard is decompressed: %v", err)
+		}
+	})
+}
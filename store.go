@@ -53,13 +53,9 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-// CreateBucket create a bucket
+// GetBucket returns a existing bucket, it works on a read only store.
 func (s *Store) GetBucket(name string, encoder EncodeFunc, decoder DecodeFunc) (*Bucket, error) {
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		if !tx.Writable() {
-			return bolt.ErrTxNotWritable
-		}
-
+	err := s.db.View(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket([]byte(name))
 		if bkt == nil {
 			return ErrBucketNotFound
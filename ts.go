@@ -5,49 +5,221 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
 )
 
+// TSEngine stores records into daily shards. Callbacks passed to its methods
+// are called without the engine locked, so they may call back into the engine,
+// but a shard read by a callback isn't opened for writing, archived, merged or
+// removed until the callback returns.
 type TSEngine struct {
+	mu          sync.Mutex
 	basePath    string
+	loc         *time.Location
 	nameWith    func(t time.Time) string
 	currentFile string
-	store       *Store
-	bkt         *Bucket
+	currentEnd  time.Time
+	writers     map[string]*shardWriter
+	closing     map[string]*shardWriter
+	handles     map[string]int
+	idle        *sync.Cond
+	watermark   time.Time
+	late        LateWritePolicy
+	lateStats   LateWriteStats
+	manifest    *manifest
+	blooms      map[string]*bloomFilter
+	rollups     []*Rollup
+	rollupMu    sync.Mutex
+	rollupTasks []rollupTask
+	rollupBusy  bool
+	retention   *retentionWorker
+	buffer      *writeBuffer
+}
+
+// shardWriter is a shard which is kept open for writing.
+type shardWriter struct {
+	file  string
+	end   time.Time
+	store *Store
+	bkt   *Bucket
+	// refs is the number of callbacks using the writer, writes is the part
+	// of them which write. A closed writer is sealed after the last write and
+	// its store is closed after the last callback.
+	refs   int
+	writes int
+	sealed bool
+}
+
+// shardHandle is a shard used by a callback which is called without the lock.
+type shardHandle struct {
+	file  string
+	bkt   *Bucket
+	store *Store
+	w     *shardWriter
+	write bool
 }
 
 func (db *TSEngine) Close() error {
+	db.StopRetention()
+	db.stopBufferWorker()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := db.close()
+	// the rollups of the closed shards are computed before the engine is closed.
+	db.waitRollups()
+	return err
+}
+
+func (db *TSEngine) close() error {
+	err := db.closeBuffer()
+	for _, w := range db.writers {
+		if e := db.closeWriter(w); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// closeFile closes the shard of file if it is open for writing.
+func (db *TSEngine) closeFile(file string) error {
+	if w := db.writers[file]; w != nil {
+		return db.closeWriter(w)
+	}
+	return nil
+}
+
+// closeWriter seals the shard of w, it is sealed by the last write if writes
+// are running.
+func (db *TSEngine) closeWriter(w *shardWriter) error {
+	delete(db.writers, w.file)
+	if db.closing == nil {
+		db.closing = map[string]*shardWriter{}
+	}
+	db.closing[w.file] = w
+	if w.writes > 0 {
+		return nil
+	}
+	return db.sealWriter(w)
+}
+
+func (db *TSEngine) sealWriter(w *shardWriter) error {
+	// the range of the rollups is read through the writer, so it is closed after.
+	err := db.rollupShard(w.bkt)
+	if e := db.manifest.seal(w.file, w.store); e != nil {
+		err = e
+	}
+	if e := db.buildBloom(w.file, w.bkt); e != nil {
+		err = e
+	}
+	w.sealed = true
+	if w.refs > 0 {
+		return err
+	}
+
+	delete(db.closing, w.file)
+	if e := w.store.Close(); e != nil {
+		err = e
+	}
+	return err
+}
+
+// acquire returns the shard of fileName for a callback, it is nil if the shard
+// doesn't exist. The shards which aren't open for writing are opened read only.
+func (db *TSEngine) acquire(fileName string, write bool) (*shardHandle, error) {
+	h := &shardHandle{file: fileName, write: write}
+	if w := db.writers[fileName]; w != nil {
+		h.w = w
+	} else if w := db.closing[fileName]; w != nil {
+		h.w = w
+	}
+
+	if h.w != nil {
+		h.bkt = h.w.bkt
+		h.w.refs++
+		if write {
+			h.w.writes++
+		}
+	} else {
+		located, ok, err := db.locate(fileName)
+		if err != nil || !ok {
+			return nil, err
+		}
+		store, bkt, err := db.openReadOnly(located)
+		if err == ErrBucketNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		h.store, h.bkt = store, bkt
+	}
+
+	if db.handles == nil {
+		db.handles = map[string]int{}
+	}
+	db.handles[fileName]++
+	return h, nil
+}
+
+// release returns the shard of h, a writer closed while it was used is sealed
+// or closed by its last callback.
+func (db *TSEngine) release(h *shardHandle) error {
 	var err error
-	if db.store != nil {
-		err = db.store.Close()
+	if h.store != nil {
+		err = h.store.Close()
+	}
+	if w := h.w; w != nil {
+		w.refs--
+		if h.write {
+			w.writes--
+		}
+		if db.closing[w.file] == w {
+			if h.write && w.writes == 0 {
+				err = db.sealWriter(w)
+			} else if w.sealed && w.refs == 0 {
+				delete(db.closing, w.file)
+				err = w.store.Close()
+			}
+		}
+	}
 
-		db.store = nil
-		db.bkt = nil
+	if db.handles[h.file]--; db.handles[h.file] <= 0 {
+		delete(db.handles, h.file)
 	}
+	db.idle.Broadcast()
 	return err
 }
 
+// waitIdle waits until no callback uses the shard of file, it returns false
+// if the shard is removed meanwhile.
+func (db *TSEngine) waitIdle(file string) bool {
+	for db.handles[file] > 0 {
+		db.idle.Wait()
+	}
+	return db.manifest.has(file)
+}
+
 func (db *TSEngine) EnforceRetention(t time.Time) error {
-	shards, err := ListShards(db.basePath, t.Location())
-	if err != nil {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushBuffer(); err != nil {
 		return err
 	}
-	return db.removeShardsBefore(shards, t)
+	return db.removeShardsBefore(db.shards(), t)
 }
 
 func (db *TSEngine) removeShardsBefore(shards Shards, t time.Time) error {
 	for _, shard := range shards {
 		if shard.startTime.Before(t) {
-			if strings.ToLower(filepath.Base(shard.path)) ==
-				strings.ToLower(db.currentFile) {
-				if err := db.Close(); err != nil {
-					return err
-				}
+			if err := db.closeFile(shard.path); err != nil {
+				return err
 			}
-			if err := os.Remove(shard.path); err != nil {
+			if err := db.removeShard(shard.path); err != nil {
 				return err
 			}
 		}
@@ -55,12 +227,26 @@ func (db *TSEngine) removeShardsBefore(shards Shards, t time.Time) error {
 	return nil
 }
 
+// removeShard removes the file and the metadata of a shard.
+func (db *TSEngine) removeShard(file string) error {
+	if !db.waitIdle(file) {
+		return nil
+	}
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	db.removeBloom(file)
+	return db.manifest.remove(file)
+}
+
+const tsBucketName = "attack"
+
 func (db *TSEngine) open(file string) (*Store, *Bucket, error) {
 	store, err := Open(file, 0666, &bolt.Options{Timeout: 10 * time.Second})
 	if err != nil {
 		return nil, nil, err
 	}
-	bkt, err := store.CreateBucketIfNotExists("attack", nil, nil)
+	bkt, err := store.CreateBucketIfNotExists(tsBucketName, nil, nil)
 	if err != nil {
 		store.Close()
 		return nil, nil, err
@@ -68,153 +254,556 @@ func (db *TSEngine) open(file string) (*Store, *Bucket, error) {
 	return store, bkt, nil
 }
 
-func (db *TSEngine) ensureOpen(t time.Time) error {
-	newFile := db.nameWith(t)
-	if db.currentFile != newFile {
-		db.Close()
-		db.currentFile = newFile
+// openReadOnly opens a sealed shard with a shared lock, so that other processes
+// are able to read it at the same time.
+func (db *TSEngine) openReadOnly(file string) (*Store, *Bucket, error) {
+	store, err := Open(file, 0444, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	bkt, err := store.GetBucket(tsBucketName, nil, nil)
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	return store, bkt, nil
+}
+
+// ensureOpen returns the writer of the shard of file which covers the day of t.
+func (db *TSEngine) ensureOpen(file string, t time.Time) (*shardWriter, error) {
+	if w := db.writers[file]; w != nil {
+		return w, nil
 	}
 
-	if db.store == nil {
-		var err error
-		db.store, db.bkt, err = db.open(db.currentFile)
+	// a closed writer which is still used by a callback is opened again.
+	w := db.closing[file]
+	if w == nil {
+		if err := db.restoreShard(file); err != nil {
+			return nil, err
+		}
+		store, bkt, err := db.open(file)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		w = &shardWriter{file: file, store: store, bkt: bkt}
 	}
-	return nil
+	if err := db.manifest.activate(file, t); err != nil {
+		if db.closing[file] != w {
+			w.store.Close()
+		}
+		return nil, err
+	}
+	delete(db.closing, file)
+	db.removeBloom(file)
+
+	w.end = db.manifest.shards[filepath.Base(file)].End
+	w.sealed = false
+	if db.writers == nil {
+		db.writers = map[string]*shardWriter{}
+	}
+	db.writers[file] = w
+	if w.end.After(db.currentEnd) {
+		db.currentFile = file
+		db.currentEnd = w.end
+	}
+	return w, nil
+}
+
+// evict closes the writers which are older than the lateness window.
+func (db *TSEngine) evict() error {
+	var err error
+	for file, w := range db.writers {
+		if file != db.currentFile && db.isLate(w.end) {
+			if e := db.closeWriter(w); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
 }
 
+// Write calls cb with the shard of t. The shards of the days within the lateness
+// window are kept open, the writes older than the window are rejected or written
+// through a shard opened for the write only, see SetLateWritePolicy.
 func (db *TSEngine) Write(t time.Time, cb func(bkt *Bucket) error) error {
-	err := db.ensureOpen(t)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// the buffered writes are written first, so that cb sees them.
+	if err := db.flushBuffer(); err != nil {
+		return err
+	}
+	w, once, err := db.writer(t)
 	if err != nil {
 		return err
 	}
-	return cb(db.bkt)
+	h, err := db.acquire(w.file, true)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Unlock()
+	err = cb(h.bkt)
+	db.mu.Lock()
+
+	if e := db.release(h); err == nil {
+		err = e
+	}
+	if once && db.writers[w.file] == w {
+		if e := db.closeWriter(w); err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return db.advance(t)
+}
+
+// shardFile returns the shard file which t is written to.
+func (db *TSEngine) shardFile(t time.Time) string {
+	if info := db.manifest.covering(t); info != nil {
+		// the day is in a merged, split or archived shard.
+		return filepath.Join(db.basePath, info.Name)
+	}
+	return db.nameWith(t)
+}
+
+func (db *TSEngine) write(t time.Time, cb func(bkt *Bucket) error) error {
+	w, once, err := db.writer(t)
+	if err != nil {
+		return err
+	}
+	err = cb(w.bkt)
+	if once {
+		if e := db.closeWriter(w); err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return db.advance(t)
+}
+
+// writer returns the writer of the shard of t, once is true if the shard is
+// opened for a late write only, it is closed after the write.
+func (db *TSEngine) writer(t time.Time) (*shardWriter, bool, error) {
+	file := db.waitWritable(t)
+	if db.trackLateness(t) {
+		if db.late.Reject {
+			db.lateStats.Rejected++
+			return nil, false, ErrLateWrite
+		}
+		db.lateStats.Redirected++
+		return db.openWriter(file, t, true)
+	}
+	return db.openWriter(file, t, false)
+}
+
+// waitWritable waits until the shard of t isn't read through a read only store
+// by a callback, it returns the file of the shard.
+func (db *TSEngine) waitWritable(t time.Time) string {
+	file := db.shardFile(t)
+	for db.writers[file] == nil && db.closing[file] == nil && db.handles[file] > 0 {
+		db.idle.Wait()
+		file = db.shardFile(t)
+	}
+	return file
+}
+
+// openWriter returns the writer of file, a shard which is out of the lateness
+// window is opened for the late writes only and once is true.
+func (db *TSEngine) openWriter(file string, t time.Time, late bool) (*shardWriter, bool, error) {
+	if late {
+		if w := db.writers[file]; w != nil {
+			return w, false, nil
+		}
+		w, err := db.ensureOpen(file, t)
+		return w, true, err
+	}
+	w, err := db.ensureOpen(file, t)
+	return w, false, err
 }
 
 func (db *TSEngine) Read(start, end time.Time, cb func(bkt *Bucket) error) error {
-	return filesRead(db.nameWith, start, end, func(position int, fileName string) error {
-		return db.read(fileName, cb)
-	})
+	visits, err := db.plan(start, end, false, false)
+	if err != nil {
+		return err
+	}
+	for _, v := range visits {
+		if err := db.view(v.file, cb); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (db *TSEngine) Get(id string, record interface{}) error {
-	time := TimeFromID(id)
-	if time.IsZero() {
-		return ErrKeyExists
+	parsed, err := ParseID(id)
+	if err != nil {
+		return err
 	}
+	// the keys are lower case, ParseID accepts upper case too.
+	id = parsed.String()
 
-	fileName := db.nameWith(time)
-	return db.read(fileName, func(bkt *Bucket) error {
-		return bkt.Get(id, record)
-	})
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flushBuffer(); err != nil {
+		return err
+	}
+
+	// the id may be in a neighbouring shard if the clock is skewed or the
+	// shard names don't match the days exactly.
+	day := startOfDay(parsed.Time(), db.loc)
+	var files []string
+	for _, offset := range []int{0, -1, 1} {
+		next := day.AddDate(0, 0, offset)
+		for _, info := range db.manifest.overlapping(next, next.AddDate(0, 0, 1)) {
+			file := filepath.Join(db.basePath, info.Name)
+			if !containsString(files, file) {
+				files = append(files, file)
+			}
+		}
+	}
+	for _, fileName := range files {
+		if f := db.bloom(fileName); f != nil && !f.test([]byte(id)) {
+			continue
+		}
+
+		found := false
+		err = db.read(fileName, func(bkt *Bucket) error {
+			found = true
+			return bkt.Get(id, record)
+		})
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if err == nil && found {
+			return nil
+		}
+	}
+	return ErrNotFound
 }
 
+// read calls cb with the shard of fileName, it never creates a shard, cb isn't
+// called if the shard doesn't exist.
 func (db *TSEngine) read(fileName string, cb func(bkt *Bucket) error) error {
-	if fileName == db.currentFile {
-		if db.store == nil {
-			store, bkt, err := db.open(db.currentFile)
-			if err != nil {
+	h, err := db.acquire(fileName, false)
+	if err != nil || h == nil {
+		return err
+	}
+	err = cb(h.bkt)
+	if e := db.release(h); err == nil {
+		err = e
+	}
+	return err
+}
+
+// view is same as read, but cb is called without the lock.
+func (db *TSEngine) view(fileName string, cb func(bkt *Bucket) error) error {
+	db.mu.Lock()
+	h, err := db.acquire(fileName, false)
+	db.mu.Unlock()
+	if err != nil || h == nil {
+		return err
+	}
+
+	err = cb(h.bkt)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if e := db.release(h); err == nil {
+		err = e
+	}
+	return err
+}
+
+// locate returns the file to read for the shard of fileName, it is the
+// decompressed copy if the shard is archived, ok is false if there is no
+// data in the shard.
+func (db *TSEngine) locate(fileName string) (string, bool, error) {
+	if db.writers[fileName] != nil || db.closing[fileName] != nil {
+		return fileName, true, nil
+	}
+	if !db.manifest.has(fileName) {
+		return "", false, nil
+	}
+	if _, err := os.Stat(fileName); err == nil {
+		return fileName, true, nil
+	} else if !os.IsNotExist(err) {
+		return "", false, err
+	}
+	return db.archivedFile(fileName)
+}
+
+func (db *TSEngine) Query(start, end time.Time, cb func(it *Iterator) error) error {
+	return db.queryShards(start, end, false, cb, nil)
+}
+
+// GapFunc is called with a time range which has no shard.
+type GapFunc func(start, end time.Time) error
+
+// QueryGaps is same as Query, and it calls onGap with the time ranges between
+// start and end which have no shard, consecutive days are merged into one gap.
+func (db *TSEngine) QueryGaps(start, end time.Time, cb func(it *Iterator) error, onGap GapFunc) error {
+	var gapStart, gapEnd time.Time
+	err := db.queryShards(start, end, false, cb, func(s, e time.Time) error {
+		if !gapEnd.IsZero() && !gapEnd.Equal(s) {
+			if err := onGap(gapStart, gapEnd); err != nil {
 				return err
 			}
-			db.store = store
-			db.bkt = bkt
+			gapEnd = time.Time{}
+		}
+		if gapEnd.IsZero() {
+			gapStart = s
 		}
+		gapEnd = e
+		return nil
+	})
+	if err != nil || gapEnd.IsZero() {
+		return err
+	}
+	return onGap(gapStart, gapEnd)
+}
 
-		return cb(db.bkt)
+// ErrStop is returned by a callback of QueryReverse to stop the query without error
+var ErrStop = errors.New("stop the query")
+
+// QueryReverse is same as Query, but the shards are visited from newest to oldest
+// and each shard is iterated backwards, so that reading the last records before
+// end is cheap. cb returns ErrStop to skip the remaining shards.
+func (db *TSEngine) QueryReverse(start, end time.Time, cb func(it *Iterator) error) error {
+	err := db.queryShards(start, end, true, cb, nil)
+	if err == ErrStop {
+		return nil
 	}
-	store, bkt, err := db.open(fileName)
+	return err
+}
+
+func (db *TSEngine) queryShards(start, end time.Time, reverse bool, cb func(it *Iterator) error, onGap GapFunc) error {
+	visits, err := db.plan(start, end, reverse, onGap != nil)
 	if err != nil {
 		return err
 	}
-	defer store.Close()
 
-	return cb(bkt)
-}
-
-func (db *TSEngine) Query(start, end time.Time, cb func(it *Iterator) error) error {
 	startID := CreateID(start, 0)
 	endID := CreateID(end, 0)
+	for _, v := range visits {
+		if v.file == "" {
+			err = onGap(v.start, v.end)
+		} else {
+			position := v.position
+			err = db.view(v.file, func(bkt *Bucket) error {
+				return getRange(bkt, position, reverse, startID, endID, cb)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardVisit is a shard or a gap visited by a query, file is empty for a gap.
+// start and end are the time range of the shard or the gap.
+type shardVisit struct {
+	position   int
+	file       string
+	start, end time.Time
+}
+
+// plan returns the shards and the gaps between start and end in the order of
+// eachShard, so that the callbacks of a query are called without the lock.
+func (db *TSEngine) plan(start, end time.Time, reverse, gaps bool) ([]shardVisit, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var visits []shardVisit
+	var onGap GapFunc
+	if gaps {
+		onGap = func(s, e time.Time) error {
+			visits = append(visits, shardVisit{start: s, end: e})
+			return nil
+		}
+	}
+	err := db.eachShard(start, end, reverse, onGap, func(position int, day time.Time, fileName string) error {
+		info := db.manifest.shards[filepath.Base(fileName)]
+		visits = append(visits, shardVisit{position: position, file: fileName, start: info.Start, end: info.End})
+		return nil
+	})
+	return visits, err
+}
 
-	return filesRead(db.nameWith, start, end, func(position int, fileName string) error {
-		return db.read(fileName, func(bkt *Bucket) error {
-			switch position {
-			case positionStart:
-				return bkt.GetRange(startID, "", cb)
-			case positionEnd:
-				return bkt.GetRange("", endID, cb)
-			case positionStartEnd:
-				return bkt.GetRange(startID, endID, cb)
-			default:
-				return bkt.GetRange("", "", cb)
+// eachShard calls cb with the shards which overlap the days between start and end,
+// a shard which covers several days is visited once. onGap is called with the
+// part of each day which has no shard if it isn't nil. The buffered writes are
+// written before.
+func (db *TSEngine) eachShard(start, end time.Time, reverse bool, onGap GapFunc, cb fileCallback) error {
+	if err := db.flushBuffer(); err != nil {
+		return err
+	}
+
+	visited := map[string]bool{}
+	return filesRead(db.nameWith, db.loc, start, end, reverse, func(_ int, day time.Time, _ string) error {
+		next := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, db.loc)
+		infos := db.manifest.overlapping(day, next)
+		if len(infos) == 0 {
+			if onGap == nil {
+				return nil
 			}
-		})
+			if day.Before(start) {
+				day = start
+			}
+			if next.After(end) {
+				next = end
+			}
+			return onGap(day, next)
+		}
+
+		for idx := range infos {
+			info := infos[idx]
+			if reverse {
+				info = infos[len(infos)-1-idx]
+			}
+
+			file := filepath.Join(db.basePath, info.Name)
+			if visited[file] {
+				continue
+			}
+			visited[file] = true
+
+			position := positionMiddle
+			hasStart := !info.Start.After(start) && info.End.After(start)
+			hasEnd := !info.Start.After(end) && info.End.After(end)
+			if hasStart && hasEnd {
+				position = positionStartEnd
+			} else if hasStart {
+				position = positionStart
+			} else if hasEnd {
+				position = positionEnd
+			}
+			if err := cb(position, day, file); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// getRange reads the part of a shard which is in the query range, the
+// position of the shard in the range tells which ends are limited.
+func getRange(bkt *Bucket, position int, reverse bool, startID, endID string, cb func(it *Iterator) error) error {
+	switch position {
+	case positionStart:
+		endID = ""
+	case positionEnd:
+		startID = ""
+	case positionMiddle:
+		startID, endID = "", ""
+	}
+	return bkt.getRange(startID, endID, reverse, cb)
+}
+
 const positionMiddle = 0
 const positionStart = 1
 const positionEnd = 2
 const positionStartEnd = 3
 
-type fileCallback func(position int, fileName string) error
+type fileCallback func(position int, day time.Time, fileName string) error
+
+// startOfDay returns the midnight of the day of t in loc.
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
 
-func filesRead(nameWith func(t time.Time) string, start, end time.Time, cb fileCallback) error {
+// filesRead calls cb with the start and the file name of each day between start
+// and end, days are split by the calendar of loc so that DST switches don't skip
+// or repeat a day. Days are visited from end to start if reverse is true.
+func filesRead(nameWith func(t time.Time) string, loc *time.Location, start, end time.Time, reverse bool, cb fileCallback) error {
 	if start.After(end) {
 		return errors.New("time range is invalid")
 	}
 
-	startY := start.Year()
-	startYD := start.YearDay()
-	endY := end.Year()
-	endYD := end.YearDay()
-
-	current := start
-
-	currentY := current.Year()
-	currentYD := current.YearDay()
-
-	for currentY < endY || (currentY == endY && currentYD <= endYD) {
-		///handle := cb
-		//inStart := currentY == startY && currentYD == startYD
-		//inEnd := currentY == endY && currentYD == endYD
+	first := startOfDay(start, loc)
+	last := startOfDay(end, loc)
 
-		fileName := nameWith(current)
+	day, step := first, 1
+	if reverse {
+		day, step = last, -1
+	}
+	for !day.Before(first) && !day.After(last) {
 		position := positionMiddle
-		if currentY == startY && currentYD == startYD {
-			if currentY == endY && currentYD == endYD {
+		if day.Equal(first) {
+			if day.Equal(last) {
 				position = positionStartEnd
 			} else {
 				position = positionStart
 			}
-		} else if currentY == endY && currentYD == endYD {
+		} else if day.Equal(last) {
 			position = positionEnd
 		}
 
-		if err := cb(position, fileName); nil != err {
+		if err := cb(position, day, nameWith(day)); nil != err {
 			return err
 		}
-
-		current = current.Add(24 * time.Hour)
-		currentY = current.Year()
-		currentYD = current.YearDay()
+		day = time.Date(day.Year(), day.Month(), day.Day()+step, 0, 0, 0, 0, loc)
 	}
 	return nil
 }
 
+// OpenTSEngine opens a engine in path which splits shards by the days of UTC.
 func OpenTSEngine(path string, nameWith func(t time.Time) string) (*TSEngine, error) {
-	return &TSEngine{
+	return OpenTSEngineIn(path, time.UTC, nameWith)
+}
+
+// OpenTSEngineIn opens a engine in path which splits shards by the days of loc,
+// nameWith is called with times in loc. The write-ahead log of a engine which
+// isn't closed cleanly is replayed, see BufferOptions.
+func OpenTSEngineIn(path string, loc *time.Location, nameWith func(t time.Time) string) (*TSEngine, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	m, err := loadManifest(path, loc)
+	if err != nil {
+		return nil, err
+	}
+	db := &TSEngine{
 		basePath: path,
+		loc:      loc,
+		manifest: m,
 		nameWith: func(t time.Time) string {
-			return filepath.Join(path, nameWith(t))
-		}}, nil
+			return filepath.Join(path, nameWith(t.In(loc)))
+		}}
+	db.idle = sync.NewCond(&db.mu)
+	if err := db.recoverWAL(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func tsName(t time.Time) string {
+	return strconv.Itoa(t.Year()) + "_" + strconv.Itoa(t.YearDay()) + ".ts"
 }
 
+// OpenTS opens a engine in path with the default shard names, shards are split by the days of UTC.
 func OpenTS(path string) (*TSEngine, error) {
-	return &TSEngine{
-		basePath: path,
-		nameWith: func(t time.Time) string {
-			return filepath.Join(path, strconv.Itoa(t.Year())+"_"+strconv.Itoa(t.YearDay())+".ts")
-		}}, nil
+	return OpenTSEngineIn(path, time.UTC, tsName)
+}
+
+// OpenTSIn is same as OpenTS, but shards are split by the days of loc.
+func OpenTSIn(path string, loc *time.Location) (*TSEngine, error) {
+	return OpenTSEngineIn(path, loc, tsName)
 }
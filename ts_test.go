@@ -0,0 +1,520 @@
+package borm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSGet(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		id := borm.CreateID(now, 1)
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(id, &sample{Value: 1})
+		})
+		if err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+
+		var s sample
+		if err := db.Get(id, &s); err != nil {
+			t.Fatalf("Error getting %s: %s", id, err)
+		}
+		if s.Value != 1 {
+			t.Fatalf("Got %v wanted 1", s.Value)
+		}
+
+		s = sample{}
+		if err := db.Get(strings.ToUpper(id), &s); err != nil || s.Value != 1 {
+			t.Fatalf("Got %v, %v wanted 1", s.Value, err)
+		}
+
+		if err := db.Get(borm.CreateID(now.AddDate(0, 0, -3), 1), &s); err != borm.ErrNotFound {
+			t.Fatalf("Get didn't fail! Expected %s got %v", borm.ErrNotFound, err)
+		}
+		if err := db.Get("abc", &s); err != borm.ErrInvalidID {
+			t.Fatalf("Get didn't fail! Expected %s got %v", borm.ErrInvalidID, err)
+		}
+	})
+}
+
+func TestReadsDontCreateShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeDays(t, db, start, 2)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing engine: %s", err)
+	}
+
+	// queries and gets don't create files for the days without data.
+	err = db.Query(start.AddDate(0, 0, -10), start.AddDate(0, 0, 10), func(it *borm.Iterator) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	var s sample
+	if err := db.Get(borm.CreateID(start.AddDate(0, 0, 5), 0), &s); err != borm.ErrNotFound {
+		t.Fatalf("Get didn't fail! Expected %s got %v", borm.ErrNotFound, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ts"))
+	if len(files) != 2 {
+		t.Fatalf("Got %d files wanted 2", len(files))
+	}
+}
+
+type gap struct {
+	start, end time.Time
+}
+
+func TestCallbacksCallEngine(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+		writeDays(t, db, start, 3)
+		last := start.AddDate(0, 0, 2)
+		next := last.AddDate(0, 0, 1)
+
+		// the writes go to the next day, so the shard read by the query is
+		// closed while it is read.
+		count := 0
+		err := db.Query(start, last.Add(time.Hour), func(it *borm.Iterator) error {
+			for it.Next() {
+				var s sample
+				if err := db.Get(string(it.Key()), &s); err != nil {
+					return err
+				}
+				count++
+				return db.Write(next, func(bkt *borm.Bucket) error {
+					return bkt.Insert(borm.CreateID(next, uint32(count)), &sample{Value: s.Value})
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 3 {
+			t.Fatalf("Got %d records wanted 3", count)
+		}
+
+		err = db.QueryParallel(context.Background(), start, last.Add(time.Hour), borm.ParallelOptions{}, func(kv *borm.KeyValue) error {
+			_, err := db.ShardStats(start, last)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Error querying in parallel: %s", err)
+		}
+	})
+}
+
+func TestQueryGaps(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		day := func(d, h int) time.Time {
+			return time.Date(2017, 10, d, h, 0, 0, 0, time.UTC)
+		}
+		for _, at := range []time.Time{day(2, 12), day(5, 12)} {
+			at := at
+			err := db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, 0), &sample{Value: 1})
+			})
+			if err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+
+		var count int
+		var gaps []gap
+		err := db.QueryGaps(day(1, 12), day(6, 12), func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		}, func(start, end time.Time) error {
+			gaps = append(gaps, gap{start, end})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 2 {
+			t.Fatalf("Got %d records wanted 2", count)
+		}
+
+		expected := []gap{{day(1, 12), day(2, 0)}, {day(3, 0), day(5, 0)}, {day(6, 0), day(6, 12)}}
+		if len(gaps) != len(expected) {
+			t.Fatalf("Got %v wanted %v", gaps, expected)
+		}
+		for idx := range gaps {
+			if !gaps[idx].start.Equal(expected[idx].start) || !gaps[idx].end.Equal(expected[idx].end) {
+				t.Fatalf("Got %v wanted %v", gaps, expected)
+			}
+		}
+	})
+}
+
+func countDays(t *testing.T, db *borm.TSEngine, days []time.Time, start, end time.Time) {
+	for idx, at := range days {
+		at := at
+		err := db.Write(at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(at, uint32(idx)), &sample{Value: float64(idx)})
+		})
+		if err != nil {
+			t.Fatalf("Error writing %v: %s", at, err)
+		}
+	}
+
+	var values []float64
+	err := db.Query(start, end, func(it *borm.Iterator) error {
+		for it.Next() {
+			var s sample
+			if err := it.Read(&s); err != nil {
+				return err
+			}
+			values = append(values, s.Value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if len(values) != len(days) {
+		t.Fatalf("Got %v wanted %d records", values, len(days))
+	}
+	for idx, v := range values {
+		if v != float64(idx) {
+			t.Fatalf("Got %v wanted %d records in order", values, len(days))
+		}
+	}
+	if len(db.Shards()) != len(days) {
+		t.Fatalf("Got %d shards wanted %d", len(db.Shards()), len(days))
+	}
+}
+
+func TestTSDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Error loading location: %s", err)
+	}
+
+	for _, tst := range []struct {
+		name string
+		days []time.Time
+	}{
+		{"spring forward", []time.Time{
+			time.Date(2017, 3, 11, 23, 30, 0, 0, loc),
+			time.Date(2017, 3, 12, 12, 0, 0, 0, loc),
+			time.Date(2017, 3, 13, 0, 30, 0, 0, loc),
+		}},
+		{"fall back", []time.Time{
+			time.Date(2017, 11, 4, 0, 30, 0, 0, loc),
+			time.Date(2017, 11, 5, 12, 0, 0, 0, loc),
+			time.Date(2017, 11, 6, 23, 30, 0, 0, loc),
+		}},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "borm-ts-")
+			if err != nil {
+				t.Fatalf("Error creating temp dir: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			db, err := borm.OpenTSIn(dir, loc)
+			if err != nil {
+				t.Fatalf("Error opening %s: %s", dir, err)
+			}
+			defer db.Close()
+
+			countDays(t, db, tst.days, tst.days[0], tst.days[len(tst.days)-1].Add(time.Minute))
+		})
+	}
+}
+
+func TestTSYearBoundary(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		// the engine splits days by UTC whatever the zone of the times is.
+		loc := time.FixedZone("UTC+10", 10*60*60)
+		days := []time.Time{
+			time.Date(2017, 12, 31, 12, 0, 0, 0, time.UTC).In(loc),
+			time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).In(loc),
+			time.Date(2018, 1, 2, 23, 59, 0, 0, time.UTC).In(loc),
+		}
+		countDays(t, db, days, days[0], days[len(days)-1].Add(time.Minute))
+
+		names := map[string]bool{}
+		for _, info := range db.Shards() {
+			names[info.Name] = true
+		}
+		for _, name := range []string{"2017_365.ts", "2018_1.ts", "2018_2.ts"} {
+			if !names[name] {
+				t.Fatalf("shard %s isn't found in %v", name, names)
+			}
+		}
+	})
+}
+
+func TestQueryReverse(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 12; i++ {
+			at := start.Add(time.Duration(i) * 6 * time.Hour)
+			err := db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, 0), &sample{Value: float64(i)})
+			})
+			if err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+
+		// the last 5 records before the 10th record.
+		var values []float64
+		err := db.QueryReverse(start, start.Add(9*6*time.Hour), func(it *borm.Iterator) error {
+			for it.Next() {
+				var s sample
+				if err := it.Read(&s); err != nil {
+					return err
+				}
+				values = append(values, s.Value)
+				if len(values) == 5 {
+					return borm.ErrStop
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		expected := []float64{9, 8, 7, 6, 5}
+		if len(values) != len(expected) {
+			t.Fatalf("Got %v wanted %v", values, expected)
+		}
+		for idx := range values {
+			if values[idx] != expected[idx] {
+				t.Fatalf("Got %v wanted %v", values, expected)
+			}
+		}
+
+		values = nil
+		err = db.QueryReverse(start.Add(time.Hour), start.Add(3*24*time.Hour), func(it *borm.Iterator) error {
+			for it.Next() {
+				var s sample
+				if err := it.Read(&s); err != nil {
+					return err
+				}
+				values = append(values, s.Value)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if len(values) != 11 || values[0] != 11 || values[10] != 1 {
+			t.Fatalf("Got %v wanted 11 to 1", values)
+		}
+	})
+}
+
+func TestSealedShardsShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer writer.Close()
+
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeDays(t, writer, start, 2)
+
+	infos := writer.Shards()
+	if infos[0].State != borm.ShardSealed || infos[1].State != borm.ShardActive {
+		t.Fatalf("Got states %s and %s", infos[0].State, infos[1].State)
+	}
+
+	reader, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer reader.Close()
+
+	// the sealed shard is read by the writer and the reader at the same time.
+	err = writer.Query(start, start.Add(time.Hour), func(it *borm.Iterator) error {
+		var s sample
+		return reader.Get(borm.CreateID(start, 0), &s)
+	})
+	if err != nil {
+		t.Fatalf("Error reading sealed shard: %s", err)
+	}
+}
+
+func TestLateWrites(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		if err := db.SetLateWritePolicy(borm.LateWritePolicy{Window: 2 * time.Hour}); err != nil {
+			t.Fatalf("Error setting policy: %s", err)
+		}
+
+		today := time.Date(2017, 10, 2, 0, 0, 0, 0, time.UTC)
+		write := func(at time.Time) error {
+			return db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, 0), &sample{Value: 1})
+			})
+		}
+		for _, at := range []time.Time{
+			today.Add(30 * time.Minute),
+			today.Add(-time.Hour),
+			today.Add(45 * time.Minute),
+			today.Add(-2 * time.Hour),
+			today.Add(3 * time.Hour),
+			today.Add(-3 * time.Hour),
+		} {
+			if err := write(at); err != nil {
+				t.Fatalf("Error writing %v: %s", at, err)
+			}
+		}
+
+		stats := db.LateWriteStats()
+		if stats.OutOfOrder != 3 || stats.Late != 1 || stats.Redirected != 1 || stats.MaxLateness != 6*time.Hour {
+			t.Fatalf("Got %#v", stats)
+		}
+
+		if err := db.SetLateWritePolicy(borm.LateWritePolicy{Window: 2 * time.Hour, Reject: true}); err != nil {
+			t.Fatalf("Error setting policy: %s", err)
+		}
+		if err := write(today.Add(-4 * time.Hour)); err != borm.ErrLateWrite {
+			t.Fatalf("Write didn't fail! Expected %s got %v", borm.ErrLateWrite, err)
+		}
+		if stats := db.LateWriteStats(); stats.Rejected != 1 {
+			t.Fatalf("Got %#v", stats)
+		}
+
+		var count int
+		err := db.Query(today.Add(-24*time.Hour), today.Add(24*time.Hour), func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 6 {
+			t.Fatalf("Got %d records wanted 6", count)
+		}
+		for _, info := range db.Shards() {
+			if info.Name == "2017_274.ts" && info.State != borm.ShardSealed {
+				t.Fatalf("Got state %s wanted %s", info.State, borm.ShardSealed)
+			}
+		}
+	})
+}
+
+func TestLateWindowShards(t *testing.T) {
+	tsWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		if err := db.SetLateWritePolicy(borm.LateWritePolicy{Window: 2 * time.Hour}); err != nil {
+			t.Fatalf("Error setting policy: %s", err)
+		}
+
+		today := time.Date(2017, 10, 2, 0, 0, 0, 0, time.UTC)
+		write := func(at time.Time) error {
+			return db.Write(at, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(at, 0), &sample{Value: 1})
+			})
+		}
+		for _, at := range []time.Time{today.Add(-30 * time.Minute), today.Add(30 * time.Minute)} {
+			if err := write(at); err != nil {
+				t.Fatalf("Error writing %v: %s", at, err)
+			}
+		}
+
+		// both shards are still open for writing within the window.
+		for _, info := range db.Shards() {
+			if info.State != borm.ShardActive {
+				t.Fatalf("Got state %s of %s wanted %s", info.State, info.Name, borm.ShardActive)
+			}
+		}
+
+		// a failed write doesn't move the newest write time.
+		errFailed := errors.New("failed")
+		err := db.Write(today.AddDate(0, 0, 1), func(bkt *borm.Bucket) error {
+			return errFailed
+		})
+		if err != errFailed {
+			t.Fatalf("Write didn't fail! Expected %s got %v", errFailed, err)
+		}
+		if err := write(today.Add(-time.Hour)); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+		if stats := db.LateWriteStats(); stats.Late != 0 {
+			t.Fatalf("Got %#v", stats)
+		}
+	})
+}
+
+func TestGetNeighbourShard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	// the clock of the writer is skewed, the id is in the shard of the next day.
+	day := time.Date(2017, 10, 2, 0, 0, 0, 0, time.UTC)
+	id := borm.CreateID(day.Add(-time.Second), 1)
+	for _, at := range []time.Time{day.Add(time.Second), day.AddDate(0, 0, 1)} {
+		at := at
+		err := db.Write(at, func(bkt *borm.Bucket) error {
+			if at.Equal(day.Add(time.Second)) {
+				return bkt.Insert(id, &sample{Value: 7})
+			}
+			return bkt.Insert(borm.CreateID(at, 0), &sample{Value: 1})
+		})
+		if err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".2017_275.ts.bloom")); err != nil {
+		t.Fatalf("bloom filter of the sealed shard isn't found: %s", err)
+	}
+
+	var s sample
+	if err := db.Get(id, &s); err != nil {
+		t.Fatalf("Error getting %s: %s", id, err)
+	}
+	if s.Value != 7 {
+		t.Fatalf("Got %v wanted 7", s.Value)
+	}
+
+	if err := db.Get(borm.CreateID(day, 2), &s); err != borm.ErrNotFound {
+		t.Fatalf("Get didn't fail! Expected %s got %v", borm.ErrNotFound, err)
+	}
+}